@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// dkimSignatureLineLength DKIM-Signature中b=按该长度折行，避免超过SMTP的行长度限制
+const dkimSignatureLineLength = 72
+
+// dkimSignedHeaders 参与签名的头部，邮件中不存在的头部不会出现在h=中
+var dkimSignedHeaders = []string{
+	"From", "Reply-To", "Subject", "Date", "To", "Cc", "Message-ID", "In-Reply-To", "References",
+	"MIME-Version", "Content-Type", "Content-Transfer-Encoding",
+}
+
+// DKIMSigner 发件域名的DKIM签名密钥，由msps下发
+type DKIMSigner struct {
+	Domain     string `json:"domain"`      // 签名域(d=)
+	Selector   string `json:"selector"`    // 选择器(s=)
+	PrivateKey string `json:"private_key"` // 私钥(PEM，RSA或Ed25519)
+}
+
+// signDKIM 在渲染好的邮件最前面加入DKIM-Signature头部，头部与正文均使用relaxed规范化(RFC 6376)
+func signDKIM(raw []byte, signer *DKIMSigner, now time.Time) ([]byte, error) {
+	key, algorithm, opts, err := parseDKIMKey(signer.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	sep := bytes.Index(raw, []byte("\r\n\r\n"))
+	if sep < 0 {
+		return nil, errors.New("message has no header/body separator")
+	}
+	bodyHash := sha256.Sum256(dkimRelaxedBody(raw[sep+4:]))
+	fields := splitHeaderFields(raw[:sep])
+
+	// 同名头部从下往上依次签名
+	hash := sha256.New()
+	used := make([]bool, len(fields))
+	var names []string
+	for _, name := range dkimSignedHeaders {
+		for i := len(fields) - 1; i >= 0; i-- {
+			if used[i] || !strings.EqualFold(headerFieldName(fields[i]), name) {
+				continue
+			}
+			used[i] = true
+			names = append(names, name)
+			hash.Write([]byte(dkimRelaxedHeader(fields[i]) + "\r\n"))
+			break
+		}
+	}
+
+	header := fmt.Sprintf("DKIM-Signature: v=1; a=%s; c=relaxed/relaxed; d=%s; s=%s;\r\n\tt=%d; h=%s;\r\n\tbh=%s;\r\n\tb=",
+		algorithm, signer.Domain, signer.Selector, now.Unix(), strings.Join(names, ":"),
+		base64.StdEncoding.EncodeToString(bodyHash[:]))
+	// 签名自身的DKIM-Signature头部时b=为空且不带结尾的CRLF
+	hash.Write([]byte(dkimRelaxedHeader(header)))
+
+	signature, err := key.Sign(rand.Reader, hash.Sum(nil), opts)
+	if err != nil {
+		return nil, fmt.Errorf("sign message failed: %w", err)
+	}
+
+	b := base64.StdEncoding.EncodeToString(signature)
+	var folded []string
+	for len(b) > dkimSignatureLineLength {
+		folded = append(folded, b[:dkimSignatureLineLength])
+		b = b[dkimSignatureLineLength:]
+	}
+	folded = append(folded, b)
+
+	signed := []byte(header + strings.Join(folded, "\r\n\t") + "\r\n")
+	return append(signed, raw...), nil
+}
+
+// parseDKIMKey 解析PKCS#1或PKCS#8格式的私钥，返回签名算法(a=)及签名参数
+func parseDKIMKey(pemKey string) (crypto.Signer, string, crypto.SignerOpts, error) {
+	block, _ := pem.Decode([]byte(pemKey))
+	if block == nil {
+		return nil, "", nil, errors.New("invalid DKIM private key PEM")
+	}
+
+	var key any
+	var err error
+	if block.Type == "RSA PRIVATE KEY" {
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	} else {
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, "", nil, fmt.Errorf("parse DKIM private key failed: %w", err)
+	}
+
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		return k, "rsa-sha256", crypto.SHA256, nil
+	case ed25519.PrivateKey:
+		// RFC 8463: Ed25519对头部的SHA-256摘要签名
+		return k, "ed25519-sha256", crypto.Hash(0), nil
+	}
+	return nil, "", nil, errors.New("unsupported DKIM private key type")
+}
+
+// splitHeaderFields 将头部拆分为字段，折行的续行归入上一个字段
+func splitHeaderFields(header []byte) []string {
+	var fields []string
+	for _, line := range strings.Split(string(header), "\r\n") {
+		if (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) && len(fields) > 0 {
+			fields[len(fields)-1] += "\r\n" + line
+			continue
+		}
+		fields = append(fields, line)
+	}
+	return fields
+}
+
+// headerFieldName 头部字段的名称
+func headerFieldName(field string) string {
+	name, _, _ := strings.Cut(field, ":")
+	return strings.TrimSpace(name)
+}
+
+// dkimRelaxedHeader relaxed头部规范化：名称小写，去掉折行，连续空白合并为一个空格，去掉冒号前后及行尾的空白
+func dkimRelaxedHeader(field string) string {
+	name, value, _ := strings.Cut(field, ":")
+	value = dkimCollapseSpace(strings.ReplaceAll(value, "\r\n", ""))
+	return strings.ToLower(strings.Trim(name, " \t")) + ":" + strings.Trim(value, " ")
+}
+
+// dkimRelaxedBody relaxed正文规范化：行内连续空白合并为一个空格，去掉行尾空白和末尾空行
+func dkimRelaxedBody(body []byte) []byte {
+	lines := strings.Split(string(body), "\r\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(dkimCollapseSpace(line), " ")
+	}
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	if len(lines) == 0 {
+		return nil
+	}
+	return []byte(strings.Join(lines, "\r\n") + "\r\n")
+}
+
+// dkimCollapseSpace 将连续的空格和制表符合并为一个空格
+func dkimCollapseSpace(s string) string {
+	var b strings.Builder
+	b.Grow(len(s))
+	space := false
+	for i := 0; i < len(s); i++ {
+		if s[i] == ' ' || s[i] == '\t' {
+			space = true
+			continue
+		}
+		if space {
+			b.WriteByte(' ')
+			space = false
+		}
+		b.WriteByte(s[i])
+	}
+	if space {
+		b.WriteByte(' ')
+	}
+	return b.String()
+}
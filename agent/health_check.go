@@ -2,7 +2,6 @@ package main
 
 import (
 	"context"
-	"github.com/denisbrodbeck/machineid"
 	"os"
 	"time"
 
@@ -16,12 +15,14 @@ const (
 )
 
 type HeartbeatReq struct {
-	ID       string `json:"id"`       // agent唯一标识
-	Hostname string `json:"hostname"` // agent所在主机名
+	ID       string   `json:"id"`       // agent唯一标识
+	Hostname string   `json:"hostname"` // agent所在主机名
+	Version  string   `json:"version"`  // agent版本
+	Tags     []string `json:"tags"`     // agent所属的池或能力标签
 }
 
 // HealthCheck 健康检查
-func HealthCheck(ctx context.Context, client *resty.Client) error {
+func HealthCheck(ctx context.Context, client *resty.Client, agentId string, tags []string) error {
 	ticker := time.NewTicker(healthCheckTimeoutSeconds * time.Second)
 	for {
 		select {
@@ -34,17 +35,13 @@ func HealthCheck(ctx context.Context, client *resty.Client) error {
 				break
 			}
 
-			agentId, err := machineid.ID()
-			if err != nil {
-				log.Warnf("Failed to get machine id: %v", err)
-				break
-			}
-
 			_, _ = client.R().SetContext(ctx).
 				SetContentLength(true).
 				SetBody(HeartbeatReq{
 					ID:       agentId,
 					Hostname: hostname,
+					Version:  version,
+					Tags:     tags,
 				}).
 				SetDoNotParseResponse(true).
 				Post(healthCheckUrl)
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// htmlSkippedElements 生成纯文本时忽略其中内容的元素
+var htmlSkippedElements = map[atom.Atom]bool{
+	atom.Head: true, atom.Title: true, atom.Script: true, atom.Style: true,
+	atom.Noscript: true, atom.Template: true, atom.Iframe: true, atom.Svg: true,
+}
+
+// htmlBlockElements 块级元素前后换行，值为换行数量(2即空一行)
+var htmlBlockElements = map[atom.Atom]int{
+	atom.P: 2, atom.H1: 2, atom.H2: 2, atom.H3: 2, atom.H4: 2, atom.H5: 2, atom.H6: 2,
+	atom.Blockquote: 2, atom.Pre: 2, atom.Table: 2, atom.Ul: 2, atom.Ol: 2, atom.Dl: 2,
+	atom.Div: 1, atom.Section: 1, atom.Article: 1, atom.Header: 1, atom.Footer: 1,
+	atom.Nav: 1, atom.Aside: 1, atom.Main: 1, atom.Form: 1, atom.Figure: 1,
+	atom.Tr: 1, atom.Dt: 1, atom.Dd: 1, atom.Address: 1, atom.Center: 1,
+}
+
+// htmlToText 将HTML正文转换为可读的纯文本，作为multipart/alternative中的text/plain部分：
+// 保留段落和换行，列表项以"- "开头，链接地址写在链接文字之后，图片使用alt文字
+func htmlToText(body string) string {
+	w := &plainTextWriter{}
+	z := html.NewTokenizer(strings.NewReader(body))
+	skip := 0
+	pre := 0
+	var links []string // 尚未结束的<a>的地址
+
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			break
+		}
+		token := z.Token()
+
+		switch tt {
+		case html.TextToken:
+			if skip > 0 {
+				continue
+			}
+			if pre > 0 {
+				w.writeRaw(token.Data)
+			} else {
+				w.writeText(token.Data)
+			}
+
+		case html.StartTagToken, html.SelfClosingTagToken:
+			if htmlSkippedElements[token.DataAtom] {
+				if tt == html.StartTagToken {
+					skip++
+				}
+				continue
+			}
+			if skip > 0 {
+				continue
+			}
+			switch token.DataAtom {
+			case atom.Br:
+				w.lineBreak()
+			case atom.Hr:
+				w.block(1)
+				w.writeRaw("----------")
+				w.block(1)
+			case atom.Li:
+				w.block(1)
+				w.writeRaw("- ")
+			case atom.Td, atom.Th:
+				w.writeText(" ")
+			case atom.Img:
+				if alt := htmlAttr(token, "alt"); alt != "" {
+					w.writeText(alt)
+				}
+			case atom.A:
+				if tt == html.StartTagToken {
+					links = append(links, htmlAttr(token, "href"))
+				}
+			default:
+				if n, ok := htmlBlockElements[token.DataAtom]; ok {
+					w.block(n)
+				}
+				if token.DataAtom == atom.Pre && tt == html.StartTagToken {
+					pre++
+				}
+			}
+
+		case html.EndTagToken:
+			if htmlSkippedElements[token.DataAtom] {
+				if skip > 0 {
+					skip--
+				}
+				continue
+			}
+			if skip > 0 {
+				continue
+			}
+			switch token.DataAtom {
+			case atom.A:
+				if len(links) == 0 {
+					continue
+				}
+				href := links[len(links)-1]
+				links = links[:len(links)-1]
+				if linkWorthShowing(href, w.lastText) {
+					w.writeText(" (" + href + ")")
+				}
+			case atom.Li:
+				w.block(1)
+			default:
+				if n, ok := htmlBlockElements[token.DataAtom]; ok {
+					w.block(n)
+				}
+				if token.DataAtom == atom.Pre && pre > 0 {
+					pre--
+				}
+			}
+		}
+	}
+	return w.String()
+}
+
+// htmlAttr 取元素的属性值
+func htmlAttr(token html.Token, name string) string {
+	for _, attr := range token.Attr {
+		if attr.Key == name {
+			return strings.TrimSpace(attr.Val)
+		}
+	}
+	return ""
+}
+
+// linkWorthShowing 链接地址是否需要写在链接文字之后：锚点、脚本及与文字相同的地址不再重复
+func linkWorthShowing(href, text string) bool {
+	if href == "" || strings.HasPrefix(href, "#") {
+		return false
+	}
+	lower := strings.ToLower(href)
+	if strings.HasPrefix(lower, "javascript:") {
+		return false
+	}
+	target := strings.TrimPrefix(lower, "mailto:")
+	return !strings.EqualFold(strings.TrimSpace(text), target) && !strings.EqualFold(strings.TrimSpace(text), href)
+}
+
+// plainTextWriter 拼接纯文本，合并连续空白并控制块级元素之间的换行
+type plainTextWriter struct {
+	b        strings.Builder
+	newlines int    // 待写入的换行数量
+	space    bool   // 待写入的空格
+	lastText string // 最近写入的一段文字，用于判断链接文字是否就是地址
+}
+
+// writeText 写入普通文本，连续空白合并为一个空格
+func (w *plainTextWriter) writeText(s string) {
+	if s == "" {
+		return
+	}
+	if isHTMLSpace(s[0]) {
+		w.space = true
+	}
+	words := strings.Fields(s)
+	for i, word := range words {
+		if i > 0 {
+			w.space = true
+		}
+		w.flush()
+		w.b.WriteString(word)
+		w.lastText = word
+	}
+	if isHTMLSpace(s[len(s)-1]) {
+		w.space = true
+	}
+}
+
+// writeRaw 原样写入文本，用于<pre>中的内容和列表前缀
+func (w *plainTextWriter) writeRaw(s string) {
+	if s == "" {
+		return
+	}
+	w.flush()
+	w.b.WriteString(s)
+	w.lastText = s
+}
+
+// block 块级元素边界，保证此处至少有n个换行
+func (w *plainTextWriter) block(n int) {
+	w.newlines = max(w.newlines, n)
+	w.space = false
+}
+
+// lineBreak <br>总是换行，连续的<br>保留空行
+func (w *plainTextWriter) lineBreak() {
+	w.newlines++
+	w.space = false
+}
+
+// flush 在写入文字前补上待写入的换行或空格，文本开头不换行
+func (w *plainTextWriter) flush() {
+	if w.b.Len() == 0 {
+		w.newlines, w.space = 0, false
+		return
+	}
+	if w.newlines > 0 {
+		w.b.WriteString(strings.Repeat("\n", min(w.newlines, 2)))
+	} else if w.space && !strings.HasSuffix(w.b.String(), " ") {
+		w.b.WriteByte(' ')
+	}
+	w.newlines, w.space = 0, false
+}
+
+// String 去掉行尾空白后的文本
+func (w *plainTextWriter) String() string {
+	lines := strings.Split(w.b.String(), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(line, " \t\r")
+	}
+	text := strings.TrimSpace(strings.Join(lines, "\n"))
+	if text == "" {
+		return ""
+	}
+	return text + "\n"
+}
+
+// isHTMLSpace HTML中的空白字符
+func isHTMLSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f'
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"syscall"
+
+	"github.com/wneessen/go-mail"
+	"github.com/wneessen/go-mail/smtp"
+)
+
+// RecipientResult 单个收件人的投递结果
+type RecipientResult struct {
+	Addr     string `json:"addr"`            // 收件人地址
+	Accepted bool   `json:"accepted"`        // 服务器是否接受该收件人
+	Code     int    `json:"code,omitempty"`  // SMTP回复码
+	Reply    string `json:"reply,omitempty"` // SMTP回复内容
+}
+
+// deliver 通过已建立的SMTP连接发送邮件，逐个记录收件人的RCPT结果。
+// 部分收件人被拒绝时仍向其余收件人投递；返回的error表示整封邮件未能投递。
+// 被临时拒绝(4xx)的收件人由msps根据回复码单独重新投递。
+func deliver(sc *smtp.Client, msg *mail.Msg, body io.WriterTo) ([]RecipientResult, error) {
+	from, err := msg.GetSender(false)
+	if err != nil {
+		return nil, fmt.Errorf("get sender failed: %w", err)
+	}
+
+	rcpts, err := msg.GetRecipients()
+	if err != nil {
+		return nil, fmt.Errorf("get recipients failed: %w", err)
+	}
+
+	// 每条命令前重新设置超时，避免服务器无响应时一直阻塞
+	if err := sc.UpdateDeadline(smtpTimeout); err != nil {
+		return rejectAll(rcpts, err), fmt.Errorf("set deadline failed: %w", err)
+	}
+	if err := sc.Mail(from); err != nil {
+		_ = sc.Reset()
+		return rejectAll(rcpts, err), fmt.Errorf("MAIL FROM failed: %w", err)
+	}
+
+	results := make([]RecipientResult, len(rcpts))
+	accepted := 0
+	// 全部收件人被拒绝时，只要有一个是永久错误就按永久错误返回
+	var rejectErr error
+	for i, rcpt := range rcpts {
+		results[i].Addr = rcpt
+		if err := sc.UpdateDeadline(smtpTimeout); err != nil {
+			return rejectAll(rcpts, err), fmt.Errorf("set deadline failed: %w", err)
+		}
+		if err := sc.Rcpt(rcpt); err != nil {
+			results[i].Code, results[i].Reply = smtpReply(err)
+			if rejectErr == nil || (isTemporary(rejectErr) && !isTemporary(err)) {
+				rejectErr = err
+			}
+			continue
+		}
+		results[i].Accepted = true
+		results[i].Code = 250
+		accepted++
+	}
+
+	if accepted == 0 {
+		_ = sc.Reset()
+		return results, fmt.Errorf("all recipients rejected: %w", rejectErr)
+	}
+
+	if err := sc.UpdateDeadline(smtpTimeout); err != nil {
+		return rejectAccepted(results, err), fmt.Errorf("set deadline failed: %w", err)
+	}
+	writer, err := sc.Data()
+	if err != nil {
+		return rejectAccepted(results, err), fmt.Errorf("DATA failed: %w", err)
+	}
+
+	if _, err := body.WriteTo(writer); err != nil {
+		_ = writer.Close()
+		return rejectAccepted(results, err), fmt.Errorf("write message failed: %w", err)
+	}
+
+	// 服务器在收到完整邮件后才处理，单独给结束DATA的回复留出时间
+	if err := sc.UpdateDeadline(smtpTimeout); err != nil {
+		return rejectAccepted(results, err), fmt.Errorf("set deadline failed: %w", err)
+	}
+	if err := writer.Close(); err != nil {
+		return rejectAccepted(results, err), fmt.Errorf("message rejected: %w", err)
+	}
+
+	return results, nil
+}
+
+// smtpReply 从错误中提取SMTP回复码与回复内容
+func smtpReply(err error) (int, string) {
+	var tpErr *textproto.Error
+	if errors.As(err, &tpErr) {
+		return tpErr.Code, tpErr.Msg
+	}
+	return 0, err.Error()
+}
+
+// isTemporary 判断发送失败是否为临时错误(4xx回复、超时、连接被重置等)，临时错误可稍后重试
+func isTemporary(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	var tpErr *textproto.Error
+	if errors.As(err, &tpErr) {
+		return tpErr.Code >= 400 && tpErr.Code < 500
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+
+	return errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, context.DeadlineExceeded)
+}
+
+// rejectAll 所有收件人使用同一个失败回复
+func rejectAll(rcpts []string, err error) []RecipientResult {
+	code, reply := smtpReply(err)
+	results := make([]RecipientResult, len(rcpts))
+	for i, rcpt := range rcpts {
+		results[i] = RecipientResult{Addr: rcpt, Code: code, Reply: reply}
+	}
+	return results
+}
+
+// rejectAccepted 邮件内容被拒绝时，已接受的收件人同样视为投递失败
+func rejectAccepted(results []RecipientResult, err error) []RecipientResult {
+	code, reply := smtpReply(err)
+	for i := range results {
+		if results[i].Accepted {
+			results[i] = RecipientResult{Addr: results[i].Addr, Code: code, Reply: reply}
+		}
+	}
+	return results
+}
@@ -1,12 +1,17 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/textproto"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/go-resty/resty/v2"
@@ -17,13 +22,19 @@ import (
 const (
 	mailSendUrl               = "/a/m"
 	mailHandlerTimeoutSeconds = 5
-	defaultSmtpPort           = mail.DefaultPort
+	mailFetchWaitSeconds      = 20 // 长轮询等待时间，期间有新邮件时msps立即返回
 )
 
 type Response struct {
-	Success bool      `json:"success"`
-	Msg     string    `json:"msg"`
-	Payload *EmailReq `json:"payload"`
+	Success bool        `json:"success"`
+	Msg     string      `json:"msg"`
+	Payload []*EmailReq `json:"payload"`
+}
+
+// EmailFetchReq 获取邮件请求
+type EmailFetchReq struct {
+	Limit int `json:"limit"` // 本次最多获取的邮件数量
+	Wait  int `json:"wait"`  // 队列为空时最多等待的秒数
 }
 
 // EmailAddress 邮箱信息
@@ -34,21 +45,125 @@ type EmailAddress struct {
 
 // FileAttachment 文件附件信息
 type FileAttachment struct {
-	ContentType mail.ContentType `json:"content_type"` // 文件类型
-	Encoding    mail.Encoding    `json:"encoding"`     // 文件编码(Bas64, quoted-printable)
-	Name        string           `json:"name"`         // 文件名
-	Content     []byte           `json:"content"`      // 文件内容(base64)
+	ContentType mail.ContentType `json:"content_type"`          // 文件类型
+	Encoding    mail.Encoding    `json:"encoding"`              // 文件编码(Bas64, quoted-printable)
+	Name        string           `json:"name"`                  // 文件名
+	Content     []byte           `json:"content"`               // 文件内容(base64)
+	Disposition string           `json:"disposition,omitempty"` // attachment(默认)或inline
+	ContentID   string           `json:"content_id,omitempty"`  // 内嵌文件的Content-ID(不含尖括号)，为空时使用文件名
 }
 
+// dispositionInline 内嵌在正文中的文件，HTML中以cid:引用
+const dispositionInline = "inline"
+
+// SMTP连接加密方式
+const (
+	smtpTLSSSL              = "ssl"               // 连接建立即使用TLS(隐式TLS，通常为465端口)
+	smtpTLSStartTLS         = "starttls"          // 通过STARTTLS升级，服务器不支持时放弃发送
+	smtpTLSStartTLSOptional = "starttls-optional" // 服务器支持时通过STARTTLS升级，否则明文发送
+	smtpTLSNone             = "none"              // 不加密，仅用于内网中继
+)
+
 // SMTPServer SMTP服务器
 type SMTPServer struct {
-	Host string `json:"host"`
-	Port *int   `json:"port,omitempty"`
+	Host       string `json:"host"`
+	Port       *int   `json:"port,omitempty"`
+	TLS        string `json:"tls,omitempty"`         // 加密方式，为空时465端口使用ssl，其他端口使用starttls
+	SkipVerify bool   `json:"skip_verify,omitempty"` // 不校验服务器证书
+	CACert     string `json:"ca_cert,omitempty"`     // 校验服务器证书使用的CA证书(PEM)，为空时使用系统证书
+}
+
+// tlsMode 连接加密方式，未指定时根据端口选择
+func (s *SMTPServer) tlsMode() string {
+	if s.TLS != "" {
+		return strings.ToLower(s.TLS)
+	}
+	if s.Port == nil || *s.Port == 465 {
+		return smtpTLSSSL
+	}
+	return smtpTLSStartTLS
+}
+
+// port SMTP端口，未指定时使用加密方式对应的默认端口
+func (s *SMTPServer) port() int {
+	if s.Port != nil {
+		return *s.Port
+	}
+	switch s.tlsMode() {
+	case smtpTLSSSL:
+		return mail.DefaultPortSSL
+	case smtpTLSStartTLS, smtpTLSStartTLSOptional:
+		return mail.DefaultPortTLS
+	}
+	return mail.DefaultPort
+}
+
+// tlsOptions 根据加密方式生成邮件客户端选项
+func (s *SMTPServer) tlsOptions() ([]mail.Option, error) {
+	var opts []mail.Option
+	switch s.tlsMode() {
+	case smtpTLSSSL:
+		opts = append(opts, mail.WithSSL())
+	case smtpTLSStartTLS:
+		opts = append(opts, mail.WithTLSPolicy(mail.TLSMandatory))
+	case smtpTLSStartTLSOptional:
+		opts = append(opts, mail.WithTLSPolicy(mail.TLSOpportunistic))
+	case smtpTLSNone:
+		return []mail.Option{mail.WithTLSPolicy(mail.NoTLS)}, nil
+	default:
+		return nil, fmt.Errorf("不支持的加密方式: %s", s.TLS)
+	}
+
+	tlsConfig := &tls.Config{
+		ServerName:         s.Host,
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: s.SkipVerify,
+	}
+	if s.CACert != "" {
+		roots := x509.NewCertPool()
+		if !roots.AppendCertsFromPEM([]byte(s.CACert)) {
+			return nil, errors.New("CA证书格式错误")
+		}
+		tlsConfig.RootCAs = roots
+	}
+	return append(opts, mail.WithTLSConfig(tlsConfig)), nil
+}
+
+// EmailLease 邮件租约，需在到期前完成确认，否则邮件会被重新分配
+type EmailLease struct {
+	ID        string    `json:"id"`
+	ExpiresAt time.Time `json:"expires_at"`
 }
 
 type SMTPAuth struct {
-	User string `json:"user"` // 用户名
-	Pass string `json:"pass"` // 密码
+	User      string `json:"user"`                // 用户名
+	Pass      string `json:"pass"`                // 密码
+	Mechanism string `json:"mechanism,omitempty"` // 认证方式(plain、login、plain-noenc、login-noenc、cram-md5、scram-sha-256等)，为空时自动选择
+}
+
+// authType 解析认证方式，未指定时从服务器支持的方式中选择最安全的一种。
+// 不加密的连接上PLAIN、LOGIN须使用plain-noenc、login-noenc，后两者不能用于加密的连接，避免降级为明文发送密码
+func (a *SMTPAuth) authType(tlsMode string) (mail.SMTPAuthType, error) {
+	authType := mail.SMTPAuthAutoDiscover
+	if a.Mechanism == "" {
+		return authType, nil
+	}
+	if err := authType.UnmarshalString(a.Mechanism); err != nil {
+		return "", err
+	}
+
+	none := tlsMode == smtpTLSNone
+	switch authType {
+	case mail.SMTPAuthPlain, mail.SMTPAuthLogin:
+		if none {
+			return "", fmt.Errorf("认证方式%s不能用于不加密的连接，需使用%s-noenc", a.Mechanism, strings.ToLower(a.Mechanism))
+		}
+	case mail.SMTPAuthPlainNoEnc, mail.SMTPAuthLoginNoEnc:
+		if !none {
+			return "", fmt.Errorf("认证方式%s只能用于不加密的连接", a.Mechanism)
+		}
+	}
+	return authType, nil
 }
 
 // EmailReq 邮件请求
@@ -66,7 +181,12 @@ type EmailReq struct {
 	Encoding    *mail.Encoding   `json:"encoding,omitempty"`   // 邮件编码
 	Subject     string           `json:"subject"`              // 邮件主题
 	Body        string           `json:"body"`                 // 邮件正文
+	AltBody     string           `json:"alt_body,omitempty"`   // HTML正文的纯文本版本，为空时由HTML生成
 	Attachments []FileAttachment `json:"files,omitempty"`      // 附件列表
+	Lease       *EmailLease      `json:"lease,omitempty"`      // 租约
+	SMIME       *SMIMEOptions    `json:"smime,omitempty"`      // S/MIME签名、加密选项
+	DKIM        *DKIMSigner      `json:"dkim,omitempty"`       // DKIM签名密钥，发件域名未配置时为空
+	SMIMEKeys   *SMIMEKeys       `json:"smime_keys,omitempty"` // S/MIME证书和私钥
 }
 
 func parseContentType(contentType string) (mimeType, charset string, err error) {
@@ -92,178 +212,350 @@ func parseContentType(contentType string) (mimeType, charset string, err error)
 	return mimeType, charset, nil
 }
 
-func SendEmail(ctx context.Context, client *resty.Client) error {
-	ticker := time.NewTicker(time.Duration(mailHandlerTimeoutSeconds) * time.Second)
-	defer ticker.Stop()
+// SendEmail 按空闲worker数量批量获取邮件并并发发送，没有空闲worker时不再获取新邮件。
+// 发往同一SMTP服务器的邮件复用pool中的会话
+func SendEmail(ctx context.Context, client *resty.Client, workers int, pool *smtpPool) error {
+	if workers <= 0 {
+		workers = 1
+	}
+
+	// 所有worker结束后再关闭空闲会话
+	defer pool.closeAll()
+	go pool.evictIdle(ctx)
+
+	// 每个令牌代表一个被占用的worker
+	sem := make(chan struct{}, workers)
+	var wg sync.WaitGroup
+	defer wg.Wait()
 
 	for {
+		// 等待至少一个空闲worker，再尽可能多地占用空闲worker
 		select {
 		case <-ctx.Done():
 			return ctx.Err()
-		case <-ticker.C:
-			var reply Response
-			// 获取邮件请求
-			resp, err := client.R().
-				SetContext(ctx).
-				SetContentLength(true).
-				SetResult(&reply).
-				Post(mailSendUrl)
-			if err != nil {
-				log.Warnf("[SendEmail] 发送邮件请求错误: %v", err)
-				continue
-			}
-
-			if resp.StatusCode() != http.StatusOK || !reply.Success || reply.Payload == nil {
-				continue
+		case sem <- struct{}{}:
+		}
+		free := 1
+	acquire:
+		for free < workers {
+			select {
+			case sem <- struct{}{}:
+				free++
+			default:
+				break acquire
 			}
+		}
 
-			emailReq := reply.Payload
-			log.Debugf("[SendEmail] 收到邮件请求: %+v", emailReq)
+		started := time.Now()
+		emailReqs, err := fetchEmails(ctx, client, free)
+		if err != nil {
+			log.Warnf("[SendEmail] 获取邮件请求错误: %v", err)
+		}
 
-			// 创建邮件消息
-			msg := mail.NewMsg()
+		for _, emailReq := range emailReqs {
+			wg.Add(1)
+			go func(emailReq *EmailReq) {
+				defer func() {
+					<-sem
+					wg.Done()
+				}()
+				processEmail(ctx, client, pool, emailReq)
+			}(emailReq)
+		}
 
-			// 发件人
-			if err := msg.FromFormat(emailReq.From.Name, emailReq.From.Addr); err != nil {
-				log.Warnf("[SendEmail] 发件人格式错误: %v", err)
-				continue
-			}
+		// 归还未用上的worker
+		for i := len(emailReqs); i < free; i++ {
+			<-sem
+		}
 
-			// 收件人
-			for _, to := range emailReq.To {
-				if err := msg.AddToFormat(to.Name, to.Addr); err != nil {
-					log.Warnf("[SendEmail] 收件人格式错误: %v", err)
+		// 长轮询不可用(请求出错或msps未等待即返回空队列)时，退回到按固定间隔轮询
+		if len(emailReqs) == 0 {
+			remaining := time.Duration(mailHandlerTimeoutSeconds)*time.Second - time.Since(started)
+			if remaining > 0 {
+				select {
+				case <-ctx.Done():
+					return ctx.Err()
+				case <-time.After(remaining):
 				}
 			}
+		}
+	}
+}
 
-			// 抄送
-			for _, cc := range emailReq.CC {
-				if err := msg.AddCcFormat(cc.Name, cc.Addr); err != nil {
-					log.Warnf("[SendEmail] 抄送人格式错误: %v", err)
-				}
-			}
+// fetchEmails 获取至多limit封邮件，队列为空时返回空列表
+func fetchEmails(ctx context.Context, client *resty.Client, limit int) ([]*EmailReq, error) {
+	var reply Response
+	resp, err := client.R().
+		SetContext(ctx).
+		SetContentLength(true).
+		SetBody(EmailFetchReq{Limit: limit, Wait: mailFetchWaitSeconds}).
+		SetResult(&reply).
+		Post(mailSendUrl)
+	if err != nil {
+		return nil, err
+	}
 
-			// 密送
-			for _, bcc := range emailReq.BCC {
-				if err := msg.AddBccFormat(bcc.Name, bcc.Addr); err != nil {
-					log.Warnf("[SendEmail] 密送人格式错误: %v", err)
-				}
-			}
+	if resp.StatusCode() != http.StatusOK || !reply.Success {
+		return nil, nil
+	}
 
-			// 邮件内容处理
-			mimeType, _, err := parseContentType(string(emailReq.ContentType))
-			if err != nil {
-				log.Warnf("[SendEmail] 内容类型解析错误: %v", err)
-				continue
-			}
+	emailReqs := make([]*EmailReq, 0, len(reply.Payload))
+	for _, emailReq := range reply.Payload {
+		if emailReq == nil || emailReq.Lease == nil {
+			continue
+		}
+		emailReqs = append(emailReqs, emailReq)
+	}
 
-			switch mimeType {
-			case "text/plain":
-				msg.SetBodyString(mail.TypeTextPlain, emailReq.Body)
-			case "text/html":
-				msg.SetBodyString(mail.TypeTextHTML, emailReq.Body)
-			default:
-				log.Warnf("[SendEmail] 不支持的内容类型: %s", mimeType)
-				continue
-			}
+	return emailReqs, nil
+}
 
-			// 附件
-			if len(emailReq.Attachments) > 0 {
-				files := make([]*mail.File, len(emailReq.Attachments))
-				for i, file := range emailReq.Attachments {
-					files[i] = &mail.File{
-						ContentType: file.ContentType,
-						Enc:         file.Encoding,
-						Header:      make(textproto.MIMEHeader),
-						Name:        file.Name,
-						Writer: func(w io.Writer) (int64, error) {
-							n, err := w.Write(file.Content)
-							return int64(n), err
-						},
-					}
-				}
-				msg.SetAttachments(files)
-			}
+// processEmail 发送单封邮件并确认发送结果
+func processEmail(ctx context.Context, client *resty.Client, pool *smtpPool, emailReq *EmailReq) {
+	log.Debugf("[SendEmail] 收到邮件请求: %+v", emailReq)
 
-			// 创建SMTP客户端
-			port := defaultSmtpPort
-			if emailReq.Server.Port != nil {
-				port = *emailReq.Server.Port
-			}
+	// 创建邮件消息
+	msg := mail.NewMsg()
 
-			mailOpts := []mail.Option{
-				mail.WithPort(port),
-				mail.WithSSL(),                        // 强制SSL
-				mail.WithTLSPolicy(mail.TLSMandatory), // 强制TLS
-				mail.WithSMTPAuth(mail.SMTPAuthLogin), // 使用LOGIN认证
-				mail.WithTimeout(10 * time.Second),    // 设置超时
-			}
+	// 发件人
+	if emailReq.From == nil {
+		rejectEmail(ctx, client, emailReq, errors.New("缺少发件人"))
+		return
+	}
+	if err := msg.FromFormat(emailReq.From.Name, emailReq.From.Addr); err != nil {
+		rejectEmail(ctx, client, emailReq, fmt.Errorf("发件人格式错误: %w", err))
+		return
+	}
+
+	// 收件人
+	for _, to := range emailReq.To {
+		if err := msg.AddToFormat(to.Name, to.Addr); err != nil {
+			log.Warnf("[SendEmail] 收件人格式错误: %v", err)
+		}
+	}
+
+	// 抄送
+	for _, cc := range emailReq.CC {
+		if err := msg.AddCcFormat(cc.Name, cc.Addr); err != nil {
+			log.Warnf("[SendEmail] 抄送人格式错误: %v", err)
+		}
+	}
+
+	// 密送
+	for _, bcc := range emailReq.BCC {
+		if err := msg.AddBccFormat(bcc.Name, bcc.Addr); err != nil {
+			log.Warnf("[SendEmail] 密送人格式错误: %v", err)
+		}
+	}
+
+	// 优先级
+	if emailReq.Priority != nil {
+		msg.SetImportance(*emailReq.Priority)
+	}
 
-			// 根据端口设置SSL
-			if port == 465 {
-				mailOpts = append(mailOpts, mail.WithSSL())
+	// 邮件内容处理
+	mimeType, _, err := parseContentType(string(emailReq.ContentType))
+	if err != nil {
+		rejectEmail(ctx, client, emailReq, fmt.Errorf("内容类型解析错误: %w", err))
+		return
+	}
+
+	switch mimeType {
+	case "text/plain":
+		msg.SetBodyString(mail.TypeTextPlain, emailReq.Body)
+	case "text/html":
+		// HTML邮件以multipart/alternative发送，纯文本在前、HTML在后，客户端优先显示最后一个能显示的部分
+		altBody := emailReq.AltBody
+		if altBody == "" {
+			altBody = htmlToText(emailReq.Body)
+		}
+		msg.SetBodyString(mail.TypeTextPlain, altBody)
+		msg.AddAlternativeString(mail.TypeTextHTML, emailReq.Body)
+	default:
+		rejectEmail(ctx, client, emailReq, fmt.Errorf("不支持的内容类型: %s", mimeType))
+		return
+	}
+
+	// 附件，inline的文件作为内嵌文件与正文一起放入multipart/related
+	var attachments, embeds []*mail.File
+	for _, file := range emailReq.Attachments {
+		f := &mail.File{
+			ContentType: file.ContentType,
+			Enc:         file.Encoding,
+			Header:      make(textproto.MIMEHeader),
+			Name:        file.Name,
+			Writer: func(w io.Writer) (int64, error) {
+				n, err := w.Write(file.Content)
+				return int64(n), err
+			},
+		}
+		if !strings.EqualFold(file.Disposition, dispositionInline) {
+			attachments = append(attachments, f)
+			continue
+		}
+		if file.ContentID != "" {
+			f.Header.Set(mail.HeaderContentID.String(), "<"+file.ContentID+">")
+		}
+		embeds = append(embeds, f)
+	}
+	msg.SetAttachments(attachments)
+	msg.SetEmbeds(embeds)
+
+	// 获取SMTP会话
+	port := emailReq.Server.port()
+
+	key := smtpSessionKey{
+		host:       strings.ToLower(emailReq.Server.Host),
+		port:       port,
+		tls:        emailReq.Server.tlsMode(),
+		skipVerify: emailReq.Server.SkipVerify,
+		caCert:     emailReq.Server.CACert,
+	}
+	if emailReq.Auth != nil {
+		key.user, key.pass = emailReq.Auth.User, emailReq.Auth.Pass
+	}
+
+	// 发送邮件，S/MIME处理失败时不发送，避免发出未签名或未加密的邮件
+	var sendSuccess bool
+	var results []RecipientResult
+	body, sendErr := renderMessage(msg, emailReq)
+	if sendErr != nil {
+		log.Warnf("[SendEmail] 邮件处理失败：%v", sendErr)
+	} else if session, err := pool.get(ctx, key, func() (*mail.Client, error) {
+		return newMailClient(emailReq, port)
+	}); err != nil {
+		sendErr = err
+		if isAuthError(err) {
+			log.Errorf("[SendEmail] SMTP认证失败：%v", err)
+		} else {
+			log.Warnf("[SendEmail] 连接SMTP服务器失败：%v", err)
+		}
+	} else {
+		results, sendErr = deliver(session.sc, msg, bytes.NewReader(body))
+		if sendErr != nil {
+			log.Warnf("[SendEmail] 发送失败：%v", sendErr)
+		} else {
+			sendSuccess = true
+		}
+		// 邮件已提交，归还会话时的错误不影响发送结果
+		pool.put(session, sendErr)
+	}
+
+	verifyReq := &EmailVerifyReq{
+		ID:         emailReq.ID,
+		LeaseID:    emailReq.Lease.ID,
+		Success:    sendSuccess,
+		Recipients: results,
+	}
+	if sendErr != nil {
+		verifyReq.Temporary = isTemporary(sendErr)
+		verifyReq.Error = sendErr.Error()
+	}
+	ackEmail(ctx, client, verifyReq)
+}
+
+// rejectEmail 邮件内容有误无法发送时确认为永久失败，由msps转入死信并保留失败原因，不必等到租约过期
+func rejectEmail(ctx context.Context, client *resty.Client, emailReq *EmailReq, err error) {
+	log.Warnf("[SendEmail] 邮件 %s 无法发送: %v", emailReq.ID, err)
+	ackEmail(ctx, client, &EmailVerifyReq{
+		ID:      emailReq.ID,
+		LeaseID: emailReq.Lease.ID,
+		Success: false,
+		Error:   err.Error(),
+	})
+}
+
+// ackEmail 确认邮件发送状态（带重试）
+func ackEmail(ctx context.Context, client *resty.Client, verifyReq *EmailVerifyReq) {
+	const maxRetries = 3
+	for i := 0; i < maxRetries; i++ {
+		if err := VerifyEmail(ctx, client, verifyReq); err != nil {
+			if errors.Is(err, errLeaseLost) {
+				log.Warnf("[VerifyEmail] 邮件 %s 的租约已失效，结果未被接受", verifyReq.ID)
+				break
 			}
+			log.Warnf("[VerifyEmail] 确认请求失败(尝试 %d/%d): %v", i+1, maxRetries, err)
+			time.Sleep(1 * time.Second)
+			continue
+		}
+		break
+	}
+}
 
-			mailClient, err := mail.NewClient(emailReq.Server.Host, mailOpts...)
+// renderMessage 渲染邮件，按请求依次进行S/MIME签名、加密和DKIM签名。
+// DKIM签名失败时发送未签名的邮件
+func renderMessage(msg *mail.Msg, emailReq *EmailReq) ([]byte, error) {
+	var recipientCerts []*x509.Certificate
+	if emailReq.SMIME != nil {
+		if emailReq.SMIME.Sign {
+			if err := signSMIME(msg, emailReq.SMIMEKeys); err != nil {
+				return nil, err
+			}
+		}
+		if emailReq.SMIME.Encrypt {
+			certs, err := recipientCertificates(emailReq)
 			if err != nil {
-				log.Warnf("[SendEmail] 创建邮件客户端失败: %v", err)
-				continue
+				return nil, err
 			}
+			recipientCerts = certs
+		}
+	}
 
-			// 设置认证信息
-			if emailReq.Auth != nil {
-				mailClient.SetSMTPAuth(mail.SMTPAuthPlain)
-				mailClient.SetUsername(emailReq.Auth.User)
-				mailClient.SetPassword(emailReq.Auth.Pass)
-			}
+	var buf bytes.Buffer
+	if _, err := msg.WriteTo(&buf); err != nil {
+		return nil, fmt.Errorf("render message failed: %w", err)
+	}
+	raw := buf.Bytes()
 
-			// 发送邮件
-			var sendSuccess bool
-			if err := mailClient.DialAndSendWithContext(ctx, msg); err != nil {
-				if isAuthError(err) {
-					log.Errorf("[SendEmail] SMTP认证失败：%v", err)
-					sendSuccess = false
-				} else if isPostSubmissionError(err) {
-					log.Debugf("[SendEmail] 邮件已成功提交（服务器连接已关闭）")
-					sendSuccess = true // 标记为成功
-				} else {
-					log.Warnf("[SendEmail] 发送失败：%v", err)
-					sendSuccess = false
-				}
-			} else {
-				sendSuccess = true
-			}
+	if recipientCerts != nil {
+		encrypted, err := encryptSMIME(raw, recipientCerts)
+		if err != nil {
+			return nil, fmt.Errorf("S/MIME加密失败: %w", err)
+		}
+		raw = encrypted
+	}
 
-			// 确认邮件发送状态（带重试）
-			const maxRetries = 3
-			for i := 0; i < maxRetries; i++ {
-				if err := VerifyEmail(ctx, client, &EmailVerifyReq{
-					ID:      emailReq.ID,
-					Success: sendSuccess,
-				}); err != nil {
-					log.Warnf("[VerifyEmail] 确认请求失败(尝试 %d/%d): %v", i+1, maxRetries, err)
-					time.Sleep(1 * time.Second)
-					continue
-				}
-				break
-			}
+	if emailReq.DKIM != nil {
+		signed, err := signDKIM(raw, emailReq.DKIM, time.Now())
+		if err != nil {
+			log.Warnf("[SendEmail] DKIM签名失败，邮件将不带签名发送：%v", err)
+		} else {
+			raw = signed
 		}
 	}
+	return raw, nil
 }
 
-// 增强错误判断逻辑
-func isPostSubmissionError(err error) bool {
-	errorPatterns := []string{
-		"reset", "eof", "closed",
-		"not connected", "already closed",
+// newMailClient 按邮件请求中的服务器和认证信息创建SMTP客户端
+func newMailClient(emailReq *EmailReq, port int) (*mail.Client, error) {
+	tlsOpts, err := emailReq.Server.tlsOptions()
+	if err != nil {
+		return nil, err
 	}
-	errMsg := strings.ToLower(err.Error())
-	for _, pattern := range errorPatterns {
-		if strings.Contains(errMsg, pattern) {
-			return true
+	mailOpts := append([]mail.Option{
+		mail.WithPort(port),
+		mail.WithTimeout(smtpTimeout), // 仅限制建立连接的时间，SMTP命令的超时由会话单独设置
+	}, tlsOpts...)
+
+	// 设置认证信息，未提供时不进行认证(如内网中继)
+	if emailReq.Auth != nil {
+		authType, err := emailReq.Auth.authType(emailReq.Server.tlsMode())
+		if err != nil {
+			return nil, err
 		}
+		mailOpts = append(mailOpts,
+			mail.WithSMTPAuth(authType),
+			mail.WithUsername(emailReq.Auth.User),
+			mail.WithPassword(emailReq.Auth.Pass),
+		)
 	}
-	return false
+
+	mailClient, err := mail.NewClient(emailReq.Server.Host, mailOpts...)
+	if err != nil {
+		return nil, fmt.Errorf("创建邮件客户端失败: %w", err)
+	}
+
+	return mailClient, nil
 }
 
 func isAuthError(err error) bool {
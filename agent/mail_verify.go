@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"github.com/go-resty/resty/v2"
 	"net/http"
@@ -11,9 +12,16 @@ const (
 	mailVerifyUrl = "/a/v"
 )
 
+// errLeaseLost 租约已失效，邮件已被重新分配
+var errLeaseLost = errors.New("lease lost")
+
 type EmailVerifyReq struct {
-	ID      string `json:"id"`      // 邮件唯一标识
-	Success bool   `json:"success"` // 发送结果是否成功
+	ID         string            `json:"id"`                   // 邮件唯一标识
+	LeaseID    string            `json:"lease_id"`             // 租约标识
+	Success    bool              `json:"success"`              // 发送结果是否成功
+	Temporary  bool              `json:"temporary"`            // 失败是否为临时错误，可稍后重试
+	Error      string            `json:"error,omitempty"`      // 失败原因
+	Recipients []RecipientResult `json:"recipients,omitempty"` // 每个收件人的投递结果
 }
 
 func VerifyEmail(ctx context.Context, client *resty.Client, req *EmailVerifyReq) error {
@@ -26,6 +34,10 @@ func VerifyEmail(ctx context.Context, client *resty.Client, req *EmailVerifyReq)
 		return fmt.Errorf("verify request failed: %v", err)
 	}
 
+	if resp.StatusCode() == http.StatusConflict {
+		return errLeaseLost
+	}
+
 	if resp.StatusCode() != http.StatusOK {
 		return fmt.Errorf("verify request failed with status %d", resp.StatusCode())
 	}
@@ -1,18 +1,29 @@
 package main
 
 import (
+	"errors"
+	"fmt"
 	"os"
 	"strconv"
+	"time"
 
+	"github.com/denisbrodbeck/machineid"
 	"github.com/go-resty/resty/v2"
 	log "github.com/sirupsen/logrus"
 	"github.com/urfave/cli/v2"
 	"golang.org/x/sync/errgroup"
 )
 
+// version agent版本，构建时通过 -ldflags "-X main.version=x.y.z" 设置
+var version = "dev"
+
+// agentIdHeader 请求头中携带的agent唯一标识
+const agentIdHeader = "X-Agent-ID"
+
 func main() {
 	app := cli.NewApp()
 	app.HideHelp = true
+	app.Version = version
 	app.Flags = []cli.Flag{
 		&cli.StringFlag{
 			Name:     "host",
@@ -26,6 +37,59 @@ func main() {
 			Required: false,
 			Value:    8080,
 		},
+		&cli.BoolFlag{
+			Name:     "tls",
+			Usage:    "使用HTTPS连接msps",
+			Required: false,
+			Value:    false,
+		},
+		&cli.StringFlag{
+			Name:     "ca-file",
+			Usage:    "校验msps证书使用的CA证书(PEM)，默认使用系统根证书",
+			Required: false,
+		},
+		&cli.StringSliceFlag{
+			Name:     "pin-sha256",
+			Usage:    "msps证书链中公钥(SPKI)的SHA-256摘要(base64)，可指定多个",
+			Required: false,
+		},
+		&cli.StringFlag{
+			Name:     "key-id",
+			Usage:    "管理员签发的凭证标识",
+			EnvVars:  []string{"AGENT_KEY_ID"},
+			Required: true,
+		},
+		&cli.StringFlag{
+			Name:     "secret",
+			Usage:    "管理员签发的凭证密钥",
+			EnvVars:  []string{"AGENT_SECRET"},
+			Required: true,
+		},
+		&cli.StringSliceFlag{
+			Name:     "tag",
+			Aliases:  []string{"t"},
+			Usage:    "agent所属的池或能力标签(如dc-east、can-reach-smtp.qq.com)，可指定多个；指定了池的邮件只会分配给带有该标签的agent",
+			Required: false,
+		},
+		&cli.IntFlag{
+			Name:     "workers",
+			Aliases:  []string{"w"},
+			Usage:    "并发发送邮件的worker数量",
+			Required: false,
+			Value:    4,
+		},
+		&cli.DurationFlag{
+			Name:     "smtp-idle-timeout",
+			Usage:    "空闲SMTP会话保持的时间，期间发往同一服务器和账户的邮件复用会话；为0时每封邮件单独建立连接",
+			Required: false,
+			Value:    30 * time.Second,
+		},
+		&cli.IntFlag{
+			Name:     "smtp-max-messages",
+			Usage:    "单个SMTP会话最多发送的邮件数量，达到后重新建立连接；为0时不限制",
+			Required: false,
+			Value:    100,
+		},
 		&cli.BoolFlag{
 			Name:     "debug",
 			Aliases:  []string{"d"},
@@ -41,14 +105,31 @@ func main() {
 			//log.SetOutput(io.Discard)
 		}
 
+		agentId, err := machineid.ID()
+		if err != nil {
+			return fmt.Errorf("get machine id failed: %w", err)
+		}
+
 		client := resty.New()
-		// TODO 暂用http
-		client.SetBaseURL("http://" + c.String("host") + ":" + strconv.FormatUint(c.Uint64("port"), 10))
+		client.SetHeader(agentIdHeader, agentId)
+		signRequests(client, agentId, c.String("key-id"), c.String("secret"))
+		scheme := "http"
+		if c.Bool("tls") {
+			if err := configureTLS(client, c.String("ca-file"), c.StringSlice("pin-sha256")); err != nil {
+				return err
+			}
+			scheme = "https"
+		} else if c.String("ca-file") != "" || len(c.StringSlice("pin-sha256")) > 0 {
+			return errors.New("--ca-file and --pin-sha256 require --tls")
+		} else {
+			log.Warn("未启用--tls，SMTP认证信息将以明文传输")
+		}
+		client.SetBaseURL(scheme + "://" + c.String("host") + ":" + strconv.FormatUint(c.Uint64("port"), 10))
 
 		eg, ctx := errgroup.WithContext(c.Context)
 		// 健康检查
 		eg.Go(func() error {
-			if err := HealthCheck(ctx, client); err != nil {
+			if err := HealthCheck(ctx, client, agentId, c.StringSlice("tag")); err != nil {
 				log.Warnf("health check error: %v", err)
 				return err
 			}
@@ -58,7 +139,8 @@ func main() {
 
 		// 处理邮件请求
 		eg.Go(func() error {
-			if err := SendEmail(ctx, client); err != nil {
+			pool := newSMTPPool(c.Duration("smtp-idle-timeout"), c.Int("smtp-max-messages"))
+			if err := SendEmail(ctx, client, c.Int("workers"), pool); err != nil {
 				log.Warnf("mail handler error: %v", err)
 				return err
 			}
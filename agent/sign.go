@@ -0,0 +1,62 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+)
+
+const (
+	agentKeyHeader       = "X-Agent-Key"
+	agentTimestampHeader = "X-Timestamp"
+	agentNonceHeader     = "X-Nonce"
+	agentSignatureHeader = "X-Signature"
+)
+
+// signature 计算请求签名: HMAC-SHA256(secret, method\npath\ntimestamp\nnonce\nagentId\nsha256(body))，需与msps保持一致
+func signature(secret, method, path, timestamp, nonce, agentId string, body []byte) string {
+	bodyHash := sha256.Sum256(body)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(method + "\n" + path + "\n" + timestamp + "\n" + nonce + "\n" + agentId + "\n"))
+	mac.Write([]byte(hex.EncodeToString(bodyHash[:])))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// signRequests 使用管理员签发的凭证为每个请求签名，每次请求(含重试)使用新的时间戳和nonce
+func signRequests(client *resty.Client, agentId, keyId, secret string) {
+	client.SetPreRequestHook(func(_ *resty.Client, req *http.Request) error {
+		var body []byte
+		if req.GetBody != nil {
+			rc, err := req.GetBody()
+			if err != nil {
+				return fmt.Errorf("read request body failed: %w", err)
+			}
+			body, err = io.ReadAll(rc)
+			_ = rc.Close()
+			if err != nil {
+				return fmt.Errorf("read request body failed: %w", err)
+			}
+		}
+
+		nonce := make([]byte, 16)
+		if _, err := rand.Read(nonce); err != nil {
+			return fmt.Errorf("generate nonce failed: %w", err)
+		}
+
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		nonceHex := hex.EncodeToString(nonce)
+		req.Header.Set(agentKeyHeader, keyId)
+		req.Header.Set(agentTimestampHeader, timestamp)
+		req.Header.Set(agentNonceHeader, nonceHex)
+		req.Header.Set(agentSignatureHeader, signature(secret, req.Method, req.URL.Path, timestamp, nonceHex, agentId, body))
+		return nil
+	})
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/wneessen/go-mail"
+)
+
+// smimeLineLength 加密后的base64正文每行长度
+const smimeLineLength = 76
+
+// SMIMEOptions 邮件的S/MIME选项
+type SMIMEOptions struct {
+	Sign    bool `json:"sign"`    // 使用发件账户的证书签名
+	Encrypt bool `json:"encrypt"` // 使用收件人的证书加密
+}
+
+// SMIMEKeys 由msps下发的S/MIME证书和私钥
+type SMIMEKeys struct {
+	Certificate    string            `json:"certificate,omitempty"`     // 发件账户证书链(PEM)
+	PrivateKey     string            `json:"private_key,omitempty"`     // 发件账户私钥(PEM)
+	RecipientCerts map[string]string `json:"recipient_certs,omitempty"` // 收件人地址(小写)到证书(PEM)的映射
+}
+
+var (
+	oidData          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidEnvelopedData = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 3}
+	oidRSAEncryption = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 1}
+	oidAES256CBC     = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 42}
+)
+
+// 以下为RFC 5652中EnvelopedData的ASN.1结构，仅支持RSA密钥传输
+type cmsAlgorithmIdentifier struct {
+	Algorithm  asn1.ObjectIdentifier
+	Parameters asn1.RawValue `asn1:"optional"`
+}
+
+type cmsIssuerAndSerialNumber struct {
+	Issuer       asn1.RawValue
+	SerialNumber *big.Int
+}
+
+type cmsKeyTransRecipientInfo struct {
+	Version                int
+	RecipientIdentifier    cmsIssuerAndSerialNumber
+	KeyEncryptionAlgorithm cmsAlgorithmIdentifier
+	EncryptedKey           []byte
+}
+
+type cmsEncryptedContentInfo struct {
+	ContentType                asn1.ObjectIdentifier
+	ContentEncryptionAlgorithm cmsAlgorithmIdentifier
+	EncryptedContent           asn1.RawValue // [0] IMPLICIT OCTET STRING
+}
+
+type cmsEnvelopedData struct {
+	Version              int
+	RecipientInfos       []cmsKeyTransRecipientInfo `asn1:"set"`
+	EncryptedContentInfo cmsEncryptedContentInfo
+}
+
+type cmsContentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue // [0] EXPLICIT
+}
+
+// signSMIME 使用发件账户的证书和私钥对邮件签名，渲染时生成multipart/signed
+func signSMIME(msg *mail.Msg, keys *SMIMEKeys) error {
+	if keys == nil || keys.Certificate == "" || keys.PrivateKey == "" {
+		return errors.New("发件账户未配置S/MIME证书")
+	}
+	keyPair, err := tls.X509KeyPair([]byte(keys.Certificate), []byte(keys.PrivateKey))
+	if err != nil {
+		return fmt.Errorf("S/MIME证书或私钥无效: %w", err)
+	}
+	return msg.SignWithTLSCertificate(&keyPair)
+}
+
+// recipientCertificates 按收件人顺序取出加密所需的证书，任一收件人缺少证书时返回错误
+func recipientCertificates(emailReq *EmailReq) ([]*x509.Certificate, error) {
+	var keys map[string]string
+	if emailReq.SMIMEKeys != nil {
+		keys = emailReq.SMIMEKeys.RecipientCerts
+	}
+
+	var certs []*x509.Certificate
+	var missing []string
+	for _, list := range [][]EmailAddress{emailReq.To, emailReq.CC, emailReq.BCC} {
+		for _, rcpt := range list {
+			certPEM, ok := keys[strings.ToLower(rcpt.Addr)]
+			if !ok {
+				missing = append(missing, rcpt.Addr)
+				continue
+			}
+			cert, err := parseCertificatePEM(certPEM)
+			if err != nil {
+				return nil, fmt.Errorf("收件人 %s 的证书无效: %w", rcpt.Addr, err)
+			}
+			certs = append(certs, cert)
+		}
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("以下收件人缺少S/MIME证书: %s", strings.Join(missing, ", "))
+	}
+	return certs, nil
+}
+
+// encryptSMIME 将渲染好的邮件正文及其Content-*头部加密为application/pkcs7-mime，其余头部保持不变(RFC 8551 3.3)
+func encryptSMIME(raw []byte, certs []*x509.Certificate) ([]byte, error) {
+	sep := bytes.Index(raw, []byte("\r\n\r\n"))
+	if sep < 0 {
+		return nil, errors.New("message has no header/body separator")
+	}
+
+	var outer, inner []string
+	for _, field := range splitHeaderFields(raw[:sep]) {
+		if strings.HasPrefix(strings.ToLower(headerFieldName(field)), "content-") {
+			inner = append(inner, field)
+		} else {
+			outer = append(outer, field)
+		}
+	}
+
+	entity := []byte(strings.Join(inner, "\r\n") + "\r\n\r\n")
+	entity = append(entity, raw[sep+4:]...)
+	envelope, err := envelopeCMS(entity, certs)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	for _, field := range outer {
+		buf.WriteString(field + "\r\n")
+	}
+	buf.WriteString("Content-Type: application/pkcs7-mime; smime-type=enveloped-data; name=\"smime.p7m\"\r\n")
+	buf.WriteString("Content-Transfer-Encoding: base64\r\n")
+	buf.WriteString("Content-Disposition: attachment; filename=\"smime.p7m\"\r\n\r\n")
+	encoded := base64.StdEncoding.EncodeToString(envelope)
+	for len(encoded) > smimeLineLength {
+		buf.WriteString(encoded[:smimeLineLength] + "\r\n")
+		encoded = encoded[smimeLineLength:]
+	}
+	buf.WriteString(encoded + "\r\n")
+	return buf.Bytes(), nil
+}
+
+// envelopeCMS 使用AES-256-CBC加密内容，内容密钥以RSA PKCS#1 v1.5分别加密给每个收件人
+func envelopeCMS(content []byte, certs []*x509.Certificate) ([]byte, error) {
+	key := make([]byte, 32)
+	iv := make([]byte, aes.BlockSize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	if _, err := rand.Read(iv); err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	padding := aes.BlockSize - len(content)%aes.BlockSize
+	plaintext := append(append([]byte{}, content...), bytes.Repeat([]byte{byte(padding)}, padding)...)
+	ciphertext := make([]byte, len(plaintext))
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(ciphertext, plaintext)
+
+	recipients := make([]cmsKeyTransRecipientInfo, 0, len(certs))
+	for _, cert := range certs {
+		pub, ok := cert.PublicKey.(*rsa.PublicKey)
+		if !ok {
+			return nil, fmt.Errorf("证书 %s 不是RSA证书", cert.Subject)
+		}
+		encryptedKey, err := rsa.EncryptPKCS1v15(rand.Reader, pub, key)
+		if err != nil {
+			return nil, err
+		}
+		recipients = append(recipients, cmsKeyTransRecipientInfo{
+			RecipientIdentifier: cmsIssuerAndSerialNumber{
+				Issuer:       asn1.RawValue{FullBytes: cert.RawIssuer},
+				SerialNumber: cert.SerialNumber,
+			},
+			KeyEncryptionAlgorithm: cmsAlgorithmIdentifier{Algorithm: oidRSAEncryption, Parameters: asn1.NullRawValue},
+			EncryptedKey:           encryptedKey,
+		})
+	}
+
+	enveloped, err := asn1.Marshal(cmsEnvelopedData{
+		RecipientInfos: recipients,
+		EncryptedContentInfo: cmsEncryptedContentInfo{
+			ContentType: oidData,
+			ContentEncryptionAlgorithm: cmsAlgorithmIdentifier{
+				Algorithm:  oidAES256CBC,
+				Parameters: asn1.RawValue{Tag: asn1.TagOctetString, Bytes: iv},
+			},
+			EncryptedContent: asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, Bytes: ciphertext},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return asn1.Marshal(cmsContentInfo{
+		ContentType: oidEnvelopedData,
+		Content:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: enveloped},
+	})
+}
+
+// parseCertificatePEM 解析PEM中的第一张证书
+func parseCertificatePEM(certPEM string) (*x509.Certificate, error) {
+	rest := []byte(certPEM)
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			return nil, errors.New("no PEM certificate found")
+		}
+		if block.Type == "CERTIFICATE" {
+			return x509.ParseCertificate(block.Bytes)
+		}
+	}
+}
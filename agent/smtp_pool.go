@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/wneessen/go-mail"
+	"github.com/wneessen/go-mail/smtp"
+)
+
+// smtpTimeout SMTP连接及单条命令的超时时间
+const smtpTimeout = 10 * time.Second
+
+// smtpSessionKey 可复用SMTP会话的标识，密码或加密设置不同的请求不共用会话
+type smtpSessionKey struct {
+	host       string
+	port       int
+	tls        string
+	skipVerify bool
+	caCert     string
+	user       string
+	pass       string
+}
+
+// smtpSession 已建立并完成认证的SMTP会话
+type smtpSession struct {
+	key      smtpSessionKey
+	client   *mail.Client
+	sc       *smtp.Client
+	sent     int // 已通过该会话发送的邮件数量
+	lastUsed time.Time
+}
+
+// alive 确认空闲会话仍然可用，服务器可能已关闭空闲连接
+func (s *smtpSession) alive() bool {
+	return s.sc.UpdateDeadline(smtpTimeout) == nil && s.sc.Noop() == nil
+}
+
+// close 发送QUIT结束会话，失败时直接关闭连接
+func (s *smtpSession) close() {
+	if err := s.sc.UpdateDeadline(smtpTimeout); err == nil {
+		if err := s.client.CloseWithSMTPClient(s.sc); err == nil {
+			return
+		}
+	}
+	_ = s.sc.Close()
+}
+
+// smtpPool 按(服务器, 端口, 用户)缓存已认证的SMTP会话。
+// 连续发往同一服务器的邮件复用会话，避免每封邮件重复TCP、TLS握手和登录
+type smtpPool struct {
+	idleTimeout time.Duration // 空闲会话保持时间，为0时不复用会话
+	maxMessages int           // 单个会话最多发送的邮件数量，达到后重新建立会话，为0时不限制
+
+	mu   sync.Mutex
+	idle map[smtpSessionKey][]*smtpSession
+}
+
+func newSMTPPool(idleTimeout time.Duration, maxMessages int) *smtpPool {
+	return &smtpPool{
+		idleTimeout: idleTimeout,
+		maxMessages: maxMessages,
+		idle:        make(map[smtpSessionKey][]*smtpSession),
+	}
+}
+
+// get 取出可用的空闲会话，没有时使用newClient建立新会话
+func (p *smtpPool) get(ctx context.Context, key smtpSessionKey, newClient func() (*mail.Client, error)) (*smtpSession, error) {
+	for s := p.pop(key); s != nil; s = p.pop(key) {
+		if s.alive() {
+			log.Debugf("[SMTPPool] 复用 %s:%d 的会话，已发送 %d 封", key.host, key.port, s.sent)
+			return s, nil
+		}
+		_ = s.sc.Close()
+	}
+
+	client, err := newClient()
+	if err != nil {
+		return nil, err
+	}
+
+	sc, err := client.DialToSMTPClientWithContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// ctx只作用于建立连接，之后的SMTP命令依靠连接的超时时间避免一直阻塞
+	if err := sc.UpdateDeadline(smtpTimeout); err != nil {
+		_ = sc.Close()
+		return nil, err
+	}
+	return &smtpSession{key: key, client: client, sc: sc}, nil
+}
+
+func (p *smtpPool) pop(key smtpSessionKey) *smtpSession {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	sessions := p.idle[key]
+	if len(sessions) == 0 {
+		return nil
+	}
+	// 优先使用最近归还的会话，较早的会话更可能已被服务器关闭
+	s := sessions[len(sessions)-1]
+	if len(sessions) == 1 {
+		delete(p.idle, key)
+	} else {
+		p.idle[key] = sessions[:len(sessions)-1]
+	}
+	return s
+}
+
+// put 归还会话。发送出错时先重置事务，无法重置或已达到发送上限的会话直接关闭
+func (p *smtpPool) put(s *smtpSession, sendErr error) {
+	s.sent++
+	if sendErr != nil {
+		if s.sc.UpdateDeadline(smtpTimeout) != nil || s.sc.Reset() != nil {
+			_ = s.sc.Close()
+			return
+		}
+	}
+
+	if p.idleTimeout <= 0 || (p.maxMessages > 0 && s.sent >= p.maxMessages) {
+		s.close()
+		return
+	}
+
+	s.lastUsed = time.Now()
+	p.mu.Lock()
+	p.idle[s.key] = append(p.idle[s.key], s)
+	p.mu.Unlock()
+}
+
+// evictIdle 定期关闭空闲超时的会话，直到ctx结束
+func (p *smtpPool) evictIdle(ctx context.Context) {
+	if p.idleTimeout <= 0 {
+		return
+	}
+
+	interval := p.idleTimeout / 2
+	if interval < time.Second {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			p.closeIdle(func(s *smtpSession) bool {
+				return now.Sub(s.lastUsed) >= p.idleTimeout
+			})
+		}
+	}
+}
+
+// closeAll 关闭全部空闲会话
+func (p *smtpPool) closeAll() {
+	p.closeIdle(func(*smtpSession) bool { return true })
+}
+
+func (p *smtpPool) closeIdle(expired func(*smtpSession) bool) {
+	var closing []*smtpSession
+
+	p.mu.Lock()
+	for key, sessions := range p.idle {
+		kept := sessions[:0]
+		for _, s := range sessions {
+			if expired(s) {
+				closing = append(closing, s)
+			} else {
+				kept = append(kept, s)
+			}
+		}
+		if len(kept) == 0 {
+			delete(p.idle, key)
+		} else {
+			p.idle[key] = kept
+		}
+	}
+	p.mu.Unlock()
+
+	// QUIT可能需要等待服务器响应，不在持有锁时执行
+	for _, s := range closing {
+		s.close()
+	}
+}
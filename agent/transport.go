@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/go-resty/resty/v2"
+)
+
+// configureTLS 配置与msps通信的TLS，caFile为空时使用系统根证书。
+// pins为服务端证书链中任一证书公钥(SubjectPublicKeyInfo)的SHA-256摘要(base64)，设置后只信任匹配的证书
+func configureTLS(client *resty.Client, caFile string, pins []string) error {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return fmt.Errorf("read ca file failed: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificate found in %s", caFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if len(pins) > 0 {
+		pinned := make([][]byte, 0, len(pins))
+		for _, pin := range pins {
+			digest, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(pin, "sha256//"))
+			if err != nil || len(digest) != sha256.Size {
+				return fmt.Errorf("invalid sha256 pin: %s", pin)
+			}
+			pinned = append(pinned, digest)
+		}
+
+		// 在证书链校验通过后再校验公钥摘要
+		tlsConfig.VerifyConnection = func(cs tls.ConnectionState) error {
+			for _, chain := range cs.VerifiedChains {
+				for _, cert := range chain {
+					digest := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+					for _, pin := range pinned {
+						if bytes.Equal(digest[:], pin) {
+							return nil
+						}
+					}
+				}
+			}
+			return errors.New("server certificate does not match any pinned public key")
+		}
+	}
+
+	client.SetTLSClientConfig(tlsConfig)
+	return nil
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
 	"net/http"
 	"os"
@@ -16,6 +17,7 @@ import (
 	"github.com/urfave/cli/v2"
 	"gorm.io/gorm"
 
+	"msps/internal/app/api"
 	"msps/internal/app/config"
 	"msps/internal/app/controller"
 	"msps/internal/app/router"
@@ -108,12 +110,14 @@ func initAppDependencies(db *gorm.DB) (*router.Router, func(), error) {
 	userCtrl := controller.NewUserController(db)
 
 	// 初始化服务
-	client := controller.NewClient(db, userCtrl)
-	agent := controller.NewAgent()
+	queue := api.ProvideMailQueue(db)
+	client := controller.NewClient(db, userCtrl, queue)
+	agent := controller.NewAgent(db, queue)
 	emailCtrl := controller.NewEmailController(db, userCtrl, client, agent)
+	adminCtrl := controller.NewAdminController(db, queue, client)
 
 	// 初始化路由
-	routerInstance := router.NewRouter(agent, client, userCtrl, emailCtrl)
+	routerInstance := router.NewRouter(agent, client, userCtrl, emailCtrl, adminCtrl, db)
 
 	// 返回清理函数
 	cleanup := func() {
@@ -133,15 +137,23 @@ func configureServer(routerInstance *router.Router) *http.Server {
 
 	// 配置服务器
 	return &http.Server{
-		Addr:    ":" + strconv.Itoa(int(config.GlobalConfig().HttpPort)),
-		Handler: engine,
+		Addr:      ":" + strconv.Itoa(int(config.GlobalConfig().HttpPort)),
+		Handler:   engine,
+		TLSConfig: &tls.Config{MinVersion: tls.VersionTLS12},
 	}
 }
 
 // gracefulStartServer 优雅启动服务器
 func gracefulStartServer(server *http.Server) {
-	logrus.Infof("服务器启动于 %s", server.Addr)
-	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+	var err error
+	if cfg := config.GlobalConfig(); cfg.TLSEnabled() {
+		logrus.Infof("服务器启动于 %s (HTTPS)", server.Addr)
+		err = server.ListenAndServeTLS(cfg.TLSCertFile, cfg.TLSKeyFile)
+	} else {
+		logrus.Infof("服务器启动于 %s", server.Addr)
+		err = server.ListenAndServe()
+	}
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
 		logrus.Fatalf("服务器启动失败: %v", err)
 	}
 }
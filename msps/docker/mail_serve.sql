@@ -37,9 +37,19 @@ CREATE TABLE `user_mail_accounts` (
                                       `email` varchar(100) NOT NULL,
                                       `auth_code` varchar(255) NOT NULL,
                                       `display_name` varchar(100) DEFAULT NULL,
+                                      `pool` varchar(64) DEFAULT NULL,
                                       `status` enum('active', 'disabled') NOT NULL DEFAULT 'active',
                                       `created_at` datetime(3) NULL DEFAULT NULL,
                                       `updated_at` datetime(3) NULL DEFAULT NULL ON UPDATE CURRENT_TIMESTAMP(3),
+                                      `oauth_token_url` varchar(255) DEFAULT NULL,
+                                      `oauth_client_id` varchar(255) DEFAULT NULL,
+                                      `oauth_client_secret` varchar(255) DEFAULT NULL,
+                                      `oauth_scope` varchar(255) DEFAULT NULL,
+                                      `oauth_refresh_token` text,
+                                      `oauth_access_token` text,
+                                      `oauth_expires_at` datetime(3) NULL DEFAULT NULL,
+                                      `smime_cert` text,
+                                      `smime_key` text,
                                       PRIMARY KEY (`id`),
                                       FOREIGN KEY (`user_id`) REFERENCES `users` (`id`) ON DELETE CASCADE,
                                       UNIQUE KEY `email` (`email`)
@@ -64,12 +74,15 @@ CREATE TABLE `email_records` (
                                  `from_email` varchar(100) NOT NULL,
                                  `to_user_id` bigint(20) DEFAULT NULL,
                                  `to_email` varchar(100) NOT NULL,
-                                 `status` enum('pending', 'success', 'fail') NOT NULL DEFAULT 'pending',
+                                 `status` enum('pending', 'success', 'fail', 'cancelled') NOT NULL DEFAULT 'pending',
                                  `sent_at` datetime DEFAULT NULL,
                                  `recipient_type` ENUM('to', 'cc', 'bcc') NOT NULL DEFAULT 'to',
                                  `email_req_id` VARCHAR(36) NOT NULL,
                                  `retry_count` int NOT NULL DEFAULT 0,
                                  `last_checked_at` datetime DEFAULT NULL,
+                                 `smtp_code` int DEFAULT NULL,
+                                 `smtp_reply` varchar(512) DEFAULT NULL,
+                                 `attempt_count` int NOT NULL DEFAULT 0,
                                  PRIMARY KEY (`id`),
                                  FOREIGN KEY (`from_user_id`) REFERENCES `users` (`id`),
                                  INDEX `idx_user_id` (`from_user_id`),
@@ -99,4 +112,199 @@ BEGIN
         SET NEW.created_at = NOW(3);
     END IF;
 END //
-DELIMITER ;
\ No newline at end of file
+DELIMITER ;
+
+-- 待发送邮件队列表
+CREATE TABLE `outbound_messages` (
+                                     `id` bigint(20) NOT NULL AUTO_INCREMENT,
+                                     `email_req_id` varchar(36) NOT NULL,
+                                     `payload` longtext NOT NULL,
+                                     `user_id` bigint(20) DEFAULT NULL,
+                                     `from_addr` varchar(100) DEFAULT NULL,
+                                     `smtp_host` varchar(255) DEFAULT NULL,
+                                     `subject` varchar(255) DEFAULT NULL,
+                                     `send_at` datetime(3) DEFAULT NULL,
+                                     `priority` tinyint NOT NULL DEFAULT 1,
+                                     `pool` varchar(64) DEFAULT NULL,
+                                     `status` enum('queued', 'dispatched', 'sent', 'failed', 'cancelled') NOT NULL DEFAULT 'queued',
+                                     `lease_id` varchar(36) DEFAULT NULL,
+                                     `agent_id` varchar(64) DEFAULT NULL,
+                                     `lease_expires_at` datetime(3) DEFAULT NULL,
+                                     `dispatch_count` int NOT NULL DEFAULT 0,
+                                     `attempts` int NOT NULL DEFAULT 0,
+                                     `available_at` datetime(3) DEFAULT NULL,
+                                     `last_error` varchar(512) DEFAULT NULL,
+                                     `created_at` datetime(3) NULL DEFAULT NULL,
+                                     `updated_at` datetime(3) NULL DEFAULT NULL,
+                                     `dispatched_at` datetime(3) DEFAULT NULL,
+                                     `completed_at` datetime(3) DEFAULT NULL,
+                                     PRIMARY KEY (`id`),
+                                     INDEX `idx_outbound_messages_email_req_id` (`email_req_id`),
+                                     INDEX `idx_outbound_messages_user_id` (`user_id`),
+                                     INDEX `idx_outbound_messages_from_addr` (`from_addr`),
+                                     INDEX `idx_outbound_messages_smtp_host` (`smtp_host`),
+                                     INDEX `idx_outbound_messages_priority` (`priority`),
+                                     INDEX `idx_outbound_messages_pool` (`pool`),
+                                     INDEX `idx_outbound_messages_status` (`status`),
+                                     INDEX `idx_outbound_messages_lease_id` (`lease_id`),
+                                     INDEX `idx_outbound_messages_agent_id` (`agent_id`),
+                                     INDEX `idx_outbound_messages_lease_expires_at` (`lease_expires_at`),
+                                     INDEX `idx_outbound_messages_available_at` (`available_at`),
+                                     INDEX `idx_outbound_messages_completed_at` (`completed_at`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+-- 邮件投递尝试记录表
+CREATE TABLE `email_attempts` (
+                                  `id` bigint(20) NOT NULL AUTO_INCREMENT,
+                                  `email_record_id` bigint(20) NOT NULL,
+                                  `email_req_id` varchar(36) DEFAULT NULL,
+                                  `agent_id` varchar(64) DEFAULT NULL,
+                                  `attempt` int NOT NULL,
+                                  `result` enum('success', 'temp_fail', 'perm_fail') NOT NULL,
+                                  `smtp_code` int DEFAULT NULL,
+                                  `smtp_reply` varchar(512) DEFAULT NULL,
+                                  `next_attempt_at` datetime(3) DEFAULT NULL,
+                                  `created_at` datetime(3) NULL DEFAULT NULL,
+                                  PRIMARY KEY (`id`),
+                                  INDEX `idx_email_attempts_email_record_id` (`email_record_id`),
+                                  INDEX `idx_email_attempts_email_req_id` (`email_req_id`),
+                                  INDEX `idx_email_attempts_agent_id` (`agent_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+-- agent注册表
+CREATE TABLE `agent_nodes` (
+                               `id` varchar(64) NOT NULL,
+                               `hostname` varchar(255) DEFAULT NULL,
+                               `ip` varchar(45) DEFAULT NULL,
+                               `version` varchar(50) DEFAULT NULL,
+                               `tags` varchar(1024) DEFAULT NULL,
+                               `first_seen_at` datetime(3) NOT NULL,
+                               `last_seen_at` datetime(3) NOT NULL,
+                               PRIMARY KEY (`id`),
+                               INDEX `idx_agent_nodes_last_seen_at` (`last_seen_at`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+-- agent签名凭证表
+CREATE TABLE `agent_credentials` (
+                                     `id` varchar(36) NOT NULL,
+                                     `secret` varchar(64) NOT NULL,
+                                     `name` varchar(100) DEFAULT NULL,
+                                     `agent_id` varchar(64) DEFAULT NULL,
+                                     `created_at` datetime(3) NULL DEFAULT NULL,
+                                     `last_used_at` datetime(3) DEFAULT NULL,
+                                     `revoked_at` datetime(3) DEFAULT NULL,
+                                     PRIMARY KEY (`id`),
+                                     INDEX `idx_agent_credentials_agent_id` (`agent_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+-- 死信表
+CREATE TABLE `dead_letters` (
+                                `id` bigint(20) NOT NULL AUTO_INCREMENT,
+                                `outbound_message_id` bigint(20) NOT NULL,
+                                `email_req_id` varchar(36) NOT NULL,
+                                `user_id` bigint(20) DEFAULT NULL,
+                                `from_addr` varchar(100) DEFAULT NULL,
+                                `subject` varchar(255) DEFAULT NULL,
+                                `payload` longtext NOT NULL,
+                                `reason` enum('retries_exhausted','permanent_failure','lease_exhausted') NOT NULL,
+                                `last_error` varchar(512) DEFAULT NULL,
+                                `attempts` int(11) DEFAULT 0,
+                                `status` enum('pending','requeued','discarded') DEFAULT 'pending',
+                                `created_at` datetime(3) NULL DEFAULT NULL,
+                                `updated_at` datetime(3) NULL DEFAULT NULL,
+                                `resolved_at` datetime(3) DEFAULT NULL,
+                                PRIMARY KEY (`id`),
+                                INDEX `idx_dead_letters_outbound_message_id` (`outbound_message_id`),
+                                INDEX `idx_dead_letters_email_req_id` (`email_req_id`),
+                                INDEX `idx_dead_letters_status` (`status`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+-- 邮件提交幂等键表
+CREATE TABLE `idempotency_keys` (
+                                    `id` bigint(20) NOT NULL AUTO_INCREMENT,
+                                    `user_id` bigint(20) NOT NULL,
+                                    `key` varchar(255) NOT NULL,
+                                    `email_req_id` varchar(36) NOT NULL,
+                                    `created_at` datetime(3) NULL DEFAULT NULL,
+                                    PRIMARY KEY (`id`),
+                                    UNIQUE INDEX `idx_idempotency_keys_user_key` (`user_id`, `key`),
+                                    INDEX `idx_idempotency_keys_created_at` (`created_at`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+-- 暂停下发的发件账户表
+CREATE TABLE `queue_pauses` (
+                                `from_addr` varchar(100) NOT NULL,
+                                `reason` varchar(255) DEFAULT NULL,
+                                `paused_by` varchar(50) DEFAULT NULL,
+                                `created_at` datetime(3) NULL DEFAULT NULL,
+                                PRIMARY KEY (`from_addr`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+-- 发送限流规则表
+CREATE TABLE `rate_limits` (
+                               `id` bigint(20) NOT NULL AUTO_INCREMENT,
+                               `scope` enum('account','user','host') NOT NULL,
+                               `key` varchar(255) NOT NULL,
+                               `per_minute` int NOT NULL DEFAULT 0,
+                               `per_hour` int NOT NULL DEFAULT 0,
+                               `per_day` int NOT NULL DEFAULT 0,
+                               `created_at` datetime(3) NULL DEFAULT NULL,
+                               `updated_at` datetime(3) NULL DEFAULT NULL,
+                               PRIMARY KEY (`id`),
+                               UNIQUE INDEX `idx_rate_limits_scope_key` (`scope`, `key`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+-- 邮件下发记录表(限流统计，保留一天)
+CREATE TABLE `dispatch_logs` (
+                                 `id` bigint(20) NOT NULL AUTO_INCREMENT,
+                                 `outbound_message_id` bigint(20) NOT NULL,
+                                 `lease_id` varchar(36) DEFAULT NULL,
+                                 `user_id` bigint(20) DEFAULT NULL,
+                                 `from_addr` varchar(100) DEFAULT NULL,
+                                 `smtp_host` varchar(255) DEFAULT NULL,
+                                 `created_at` datetime(3) NULL DEFAULT NULL,
+                                 PRIMARY KEY (`id`),
+                                 INDEX `idx_dispatch_logs_lease_id` (`lease_id`),
+                                 INDEX `idx_dispatch_logs_user_id` (`user_id`),
+                                 INDEX `idx_dispatch_logs_from_addr` (`from_addr`),
+                                 INDEX `idx_dispatch_logs_smtp_host` (`smtp_host`),
+                                 INDEX `idx_dispatch_logs_created_at` (`created_at`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+-- 发件域名DKIM密钥表
+CREATE TABLE `dkim_keys` (
+                             `id` bigint(20) NOT NULL AUTO_INCREMENT,
+                             `domain` varchar(255) NOT NULL,
+                             `selector` varchar(63) NOT NULL,
+                             `algorithm` enum('rsa','ed25519') NOT NULL,
+                             `private_key` text NOT NULL,
+                             `status` enum('active','disabled') DEFAULT 'active',
+                             `created_at` datetime(3) NULL DEFAULT NULL,
+                             `updated_at` datetime(3) NULL DEFAULT NULL,
+                             PRIMARY KEY (`id`),
+                             UNIQUE INDEX `idx_dkim_keys_domain_selector` (`domain`, `selector`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+-- DKIM签名授权表，只有得到授权的用户从该域名发出的邮件才会签名
+CREATE TABLE `dkim_grants` (
+                               `id` bigint(20) NOT NULL AUTO_INCREMENT,
+                               `domain` varchar(255) NOT NULL,
+                               `user_id` bigint(20) NOT NULL,
+                               `created_at` datetime(3) NULL DEFAULT NULL,
+                               PRIMARY KEY (`id`),
+                               FOREIGN KEY (`user_id`) REFERENCES `users` (`id`) ON DELETE CASCADE,
+                               UNIQUE INDEX `idx_dkim_grants_domain_user` (`domain`, `user_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+-- 收件人S/MIME证书表
+CREATE TABLE `smime_certificates` (
+                                      `id` bigint(20) NOT NULL AUTO_INCREMENT,
+                                      `user_id` bigint(20) NOT NULL,
+                                      `email` varchar(100) NOT NULL,
+                                      `subject` varchar(255) DEFAULT NULL,
+                                      `certificate` text NOT NULL,
+                                      `not_after` datetime(3) NOT NULL,
+                                      `created_at` datetime(3) NULL DEFAULT NULL,
+                                      PRIMARY KEY (`id`),
+                                      UNIQUE INDEX `idx_smime_certificates_user_email` (`user_id`, `email`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
@@ -3,10 +3,25 @@ package api
 import (
 	"errors"
 	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"io"
 	"msps/internal/app/model/common"
 	"msps/internal/app/model/domain"
 	"net/http"
+	"strings"
 	"sync"
+	"time"
+)
+
+const (
+	// maxSmtpReplyLength 邮件记录中保存的SMTP回复最大长度
+	maxSmtpReplyLength = 512
+	// maxFetchLimit agent单次最多获取的邮件数量
+	maxFetchLimit = 100
+	// maxFetchWait agent获取邮件时最长的等待时间(秒)
+	maxFetchWait = 25
 )
 
 type MailVerifyMap struct {
@@ -41,41 +56,37 @@ func (m *MailVerifyMap) SetEmailVerifyInfo(id string, info EmailVerifyInfo) {
 	m.Map[id] = info
 }
 
-func (m *MailProbeMap) SetEmailProbeReq(id string, req domain.EmailProbeReq) {
+// DeleteEmailVerifyInfo 删除邮件的发送结果，邮件重新入队后之前的结果不再有效
+func (m *MailVerifyMap) DeleteEmailVerifyInfo(id string) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
-	m.Map[id] = req
+	delete(m.Map, id)
 }
 
-func (q *MailQueue) Dequeue() (domain.EmailReq, error) {
-	q.mu.Lock()
-	defer q.mu.Unlock()
-
-	if len(q.Queue) == 0 {
-		return domain.EmailReq{}, errQueueEmpty
-	}
-
-	req := <-q.Queue
-	q.remainingCount++
-	return req, nil
+func (m *MailProbeMap) SetEmailProbeReq(id string, req domain.EmailProbeReq) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.Map[id] = req
 }
 
 var (
-	errQueueEmpty = errors.New("queue is empty")
-	VerifyMap     = NewMailVerifyMap()
-	ProbeMap      = NewMailProbeMap()
+	VerifyMap = NewMailVerifyMap()
+	ProbeMap  = NewMailProbeMap()
 )
 
 type Agent struct {
+	DB    *gorm.DB
+	Queue *MailQueue
 }
 
 // HandleSentEmail
 // @Summary 邮件获取
-// @Description 处理来自Agent的邮件获取请求
+// @Description 处理来自Agent的邮件获取请求，一次最多返回limit封邮件；队列为空时最多等待wait秒，期间有新邮件入队立即返回
 // @tags Agent
 // @Accept json
 // @Produce json
-// @Success 200 {object} common.Response "{"success":true,"msg":"","data":null}"
+// @Param body body domain.EmailFetchReq false "请求参数"
+// @Success 200 {object} common.Response "{"success":true,"msg":"","data":[]}"
 // @Failure 400 {object} common.Response "{"success":false,"msg":"请求参数错误","data":null}"
 // @Failure 401 {object} common.Response "{"success":false,"msg":"用户未登录","data":null}"
 // @Failure 403 {object} common.Response "{"success":false,"msg":"访问受限","data":null}"
@@ -83,7 +94,33 @@ type Agent struct {
 // @Failure 500 {object} common.Response "{"success":false,"msg":"Internal Server Error","data":null}"
 // @Router /a/m [post]
 func (a *Agent) HandleSentEmail(c *gin.Context) {
-	req, err := EmailQueue.Dequeue()
+	var fetch domain.EmailFetchReq
+	if err := c.ShouldBindJSON(&fetch); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, common.NewResponse(common.WithMsg("请求参数错误")))
+		return
+	}
+
+	limit := fetch.Limit
+	if limit <= 0 {
+		limit = 1
+	} else if limit > maxFetchLimit {
+		limit = maxFetchLimit
+	}
+
+	// 由AgentAuthMiddleware校验签名后设置
+	agentID := c.GetString("agent_id")
+	wait := fetch.Wait
+	if wait > maxFetchWait {
+		wait = maxFetchWait
+	}
+
+	var reqs []domain.EmailReq
+	var err error
+	if wait > 0 {
+		reqs, err = a.Queue.DequeueWait(c.Request.Context(), agentID, limit, time.Duration(wait)*time.Second)
+	} else {
+		reqs, err = a.Queue.Dequeue(agentID, limit)
+	}
 	if err != nil {
 		if errors.Is(err, errQueueEmpty) {
 			c.JSON(http.StatusServiceUnavailable, common.NewResponse(common.WithMsg("队列为空")))
@@ -94,15 +131,16 @@ func (a *Agent) HandleSentEmail(c *gin.Context) {
 		}
 	}
 
-	c.JSON(http.StatusOK, common.NewResponse(common.WithSuccess(true), common.WithPayload(req)))
+	c.JSON(http.StatusOK, common.NewResponse(common.WithSuccess(true), common.WithPayload(reqs)))
 }
 
 // HealthCheck
 // @Summary 健康检查
-// @Description 处理来自Agent的心跳请求
+// @Description 处理来自Agent的心跳请求，记录agent信息及最近一次心跳时间
 // @tags Agent
 // @Accept json
 // @Produce json
+// @Param body body domain.HeartbeatReq true "请求参数"
 // @Success 200 {object} common.Response "{"success":true,"msg":"","data":null}"
 // @Failure 400 {object} common.Response "{"success":false,"msg":"请求参数错误","data":null}"
 // @Failure 401 {object} common.Response "{"success":false,"msg":"用户未登录","data":null}"
@@ -111,6 +149,36 @@ func (a *Agent) HandleSentEmail(c *gin.Context) {
 // @Failure 500 {object} common.Response "{"success":false,"msg":"Internal Server Error","data":null}"
 // @Router /a/h [post]
 func (a *Agent) HealthCheck(c *gin.Context) {
+	var req domain.HeartbeatReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, common.NewResponse(common.WithMsg(common.MsgInvalidParam)))
+		return
+	}
+
+	// 由AgentAuthMiddleware校验签名后设置，agent只能更新自己的信息
+	if req.ID != c.GetString("agent_id") {
+		c.JSON(http.StatusForbidden, common.NewResponse(common.WithMsg("agent标识与凭证不符")))
+		return
+	}
+
+	now := time.Now()
+	node := domain.AgentNode{
+		ID:          req.ID,
+		Hostname:    req.Hostname,
+		IP:          c.ClientIP(),
+		Version:     req.Version,
+		Tags:        normalizeTags(req.Tags),
+		FirstSeenAt: now,
+		LastSeenAt:  now,
+	}
+	if err := a.DB.Clauses(clause.OnConflict{
+		DoUpdates: clause.AssignmentColumns([]string{"hostname", "ip", "version", "tags", "last_seen_at"}),
+	}).Create(&node).Error; err != nil {
+		log.Printf("更新agent信息失败: %v", err)
+		c.JSON(http.StatusInternalServerError, common.NewResponse(common.WithMsg(common.MsgInternalServerError)))
+		return
+	}
+
 	c.JSON(http.StatusOK, common.NewResponse(common.WithSuccess(true)))
 }
 
@@ -126,6 +194,7 @@ func (a *Agent) HealthCheck(c *gin.Context) {
 // @Failure 401 {object} common.Response "{"success":false,"msg":"用户未登录","data":null}"
 // @Failure 403 {object} common.Response "{"success":false,"msg":"访问受限","data":null}"
 // @Failure 404 {object} common.Response "{"success":false,"msg":"路径不存在","data":null}"
+// @Failure 409 {object} common.Response "{"success":false,"msg":"租约不存在或已失效","data":null}"
 // @Failure 500 {object} common.Response "{"success":false,"msg":"Internal Server Error","data":null}"
 // @Router /a/v [post]
 func (a *Agent) HandleVerifyEmail(c *gin.Context) {
@@ -136,11 +205,127 @@ func (a *Agent) HandleVerifyEmail(c *gin.Context) {
 		return
 	}
 
-	// TODO 处理邮件确认请求
-	verifyInfo := EmailVerifyInfo{
-		Success: req.Success,
+	// 租约过期后邮件已被其他agent重新获取，此时的确认结果不再有效
+	ack, err := a.Queue.Ack(req)
+	if err != nil {
+		if errors.Is(err, errLeaseNotFound) {
+			c.JSON(http.StatusConflict, common.NewResponse(common.WithMsg("租约不存在或已失效")))
+			return
+		}
+		log.Printf("更新队列邮件状态失败: %v", err)
+		c.JSON(http.StatusInternalServerError, common.NewResponse(common.WithMsg(common.MsgInternalServerError)))
+		return
+	}
+
+	// 按收件人记录本次投递尝试，未上报结果的收件人按整体结果处理
+	if err := a.recordAttempt(req, ack); err != nil {
+		log.Printf("记录投递尝试失败: %v", err)
+	}
+
+	// 已安排重试的邮件仍处于pending状态，由重试结果决定最终状态
+	if ack.Final {
+		verifyInfo := EmailVerifyInfo{
+			Success: req.Success,
+		}
+		VerifyMap.SetEmailVerifyInfo(req.ID, verifyInfo)
 	}
-	VerifyMap.SetEmailVerifyInfo(req.ID, verifyInfo)
 
 	c.JSON(http.StatusOK, common.NewResponse(common.WithSuccess(true)))
 }
+
+// recordAttempt 为每条待发送的邮件记录写入本次投递尝试，并在得到最终结果时更新记录状态
+func (a *Agent) recordAttempt(req domain.EmailVerifyReq, ack AckResult) error {
+	var records []domain.EmailRecord
+	if err := a.DB.Where("email_req_id = ? AND status = ?", req.ID, "pending").
+		Find(&records).Error; err != nil {
+		return err
+	}
+
+	results := make(map[string]domain.RecipientResult, len(req.Recipients))
+	for _, result := range req.Recipients {
+		results[strings.ToLower(result.Addr)] = result
+	}
+
+	now := time.Now()
+	return a.DB.Transaction(func(tx *gorm.DB) error {
+		for _, record := range records {
+			attempt := domain.EmailAttempt{
+				EmailRecordID: record.ID,
+				EmailReqID:    req.ID,
+				AgentID:       ack.AgentID,
+				Attempt:       ack.Attempt,
+				SmtpReply:     truncateString(req.Error, maxSmtpReplyLength),
+			}
+
+			// 优先使用该收件人自己的投递结果
+			result, reported := results[strings.ToLower(record.ToEmail)]
+			if reported {
+				attempt.SmtpCode = result.Code
+				attempt.SmtpReply = truncateString(result.Reply, maxSmtpReplyLength)
+			}
+
+			temporary := req.Temporary
+			if reported && !result.Accepted && result.Code != 0 {
+				temporary = result.Code >= 400 && result.Code < 500
+			}
+
+			updateFields := map[string]interface{}{
+				"attempt_count":   record.AttemptCount + 1,
+				"smtp_code":       attempt.SmtpCode,
+				"smtp_reply":      attempt.SmtpReply,
+				"last_checked_at": now,
+			}
+
+			switch {
+			case reported && result.Accepted, !reported && req.Success:
+				attempt.Result = "success"
+				updateFields["status"] = "success"
+				updateFields["sent_at"] = now
+			case reported && !result.Accepted && !temporary:
+				// 被永久拒绝的收件人不会随邮件重新投递，即使其他收件人仍在重试
+				attempt.Result = "perm_fail"
+				updateFields["status"] = "fail"
+				updateFields["sent_at"] = now
+			case !ack.Final:
+				attempt.Result = "temp_fail"
+				attempt.NextAttemptAt = ack.NextAttempt
+			default:
+				attempt.Result = "perm_fail"
+				if temporary {
+					attempt.Result = "temp_fail"
+				}
+				updateFields["status"] = "fail"
+				updateFields["sent_at"] = now
+			}
+
+			if err := tx.Create(&attempt).Error; err != nil {
+				return err
+			}
+
+			if err := tx.Model(&domain.EmailRecord{}).
+				Where("id = ?", record.ID).
+				Updates(updateFields).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// normalizeTags 去除空白及重复的标签
+func normalizeTags(tags []string) []string {
+	result := make([]string, 0, len(tags))
+	seen := make(map[string]struct{}, len(tags))
+	for _, tag := range tags {
+		tag = strings.TrimSpace(tag)
+		if tag == "" {
+			continue
+		}
+		if _, ok := seen[tag]; ok {
+			continue
+		}
+		seen[tag] = struct{}{}
+		result = append(result, tag)
+	}
+	return result
+}
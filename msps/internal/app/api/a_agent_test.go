@@ -0,0 +1,22 @@
+package api
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNormalizeTags(t *testing.T) {
+	tests := []struct {
+		tags []string
+		want []string
+	}{
+		{nil, []string{}},
+		{[]string{" dc-east ", "", "  ", "dc-east", "can-reach-smtp.qq.com"}, []string{"dc-east", "can-reach-smtp.qq.com"}},
+		{[]string{"B", "a", "b"}, []string{"B", "a", "b"}},
+	}
+	for _, tt := range tests {
+		if got := normalizeTags(tt.tags); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("normalizeTags(%q) = %q, want %q", tt.tags, got, tt.want)
+		}
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
 	log "github.com/sirupsen/logrus"
 	"github.com/wneessen/go-mail"
 	"gorm.io/gorm"
@@ -12,7 +13,8 @@ import (
 	"msps/internal/app/model/common"
 	"msps/internal/app/model/domain"
 	"net/http"
-	"sync"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -23,6 +25,12 @@ const (
 	StatusFailed         VerifyStatus = 2 // 发送失败
 )
 
+const (
+	// IdempotencyKeyHeader 客户端提交邮件时携带的幂等键
+	IdempotencyKeyHeader    = "Idempotency-Key"
+	maxIdempotencyKeyLength = 255
+)
+
 // EmailCheckRequest 定义检查邮箱请求结构
 type EmailCheckRequest struct {
 	Sender     string   `json:"sender" binding:"required,email"`
@@ -31,34 +39,6 @@ type EmailCheckRequest struct {
 
 type VerifyStatus int
 
-type MailQueue struct {
-	Queue          chan domain.EmailReq
-	QueueCapacity  int
-	remainingCount int
-	mu             sync.Mutex
-}
-
-func NewMailQueue(capacity int) *MailQueue {
-	return &MailQueue{
-		Queue:          make(chan domain.EmailReq, capacity),
-		QueueCapacity:  capacity,
-		remainingCount: capacity,
-	}
-}
-
-func (q *MailQueue) Enqueue(req domain.EmailReq) error {
-	q.mu.Lock()
-	defer q.mu.Unlock()
-
-	if q.remainingCount == 0 {
-		return errQueueFull
-	}
-
-	q.Queue <- req
-	q.remainingCount--
-	return nil
-}
-
 func (m *MailVerifyMap) CheckMap(id string) VerifyStatus {
 	m.mu.Lock()
 	defer m.mu.Unlock()
@@ -83,24 +63,22 @@ func (m *MailProbeMap) CheckMap(id string) (domain.EmailProbeReq, bool) {
 	return domain.EmailProbeReq{}, false
 }
 
-var (
-	EmailQueue   = NewMailQueue(defaultQueueCapacity)
-	errQueueFull = errors.New("queue is full")
-)
-
 type Client struct {
-	DB *gorm.DB
+	DB    *gorm.DB
+	Queue *MailQueue
 }
 
 // HandleSentEmail
 // @Summary 邮件发送处理
-// @Description 处理来自Client的邮件发送请求
+// @Description 处理来自Client的邮件发送请求，邮件唯一标识由服务端生成并在响应中返回
 // @tags Client
 // @Accept multipart/form-data
 // @Produce json
+// @Param Idempotency-Key header string false "幂等键，有效期内重复提交返回首次提交的结果"
 // @Param data formData string true "邮件请求参数，作为formData的'data'字段传递"
 // @Param attachments formData file false "邮件附件，作为formData的'attachments'字段传递（可选）"
-// @Success 200 {object} common.Response "{"success":true,"msg":"","data":null}"
+// @Param inline formData file false "内嵌文件，作为formData的'inline'字段传递（可选），HTML正文中以cid:引用，Content-ID取自该部分的Content-ID头部，为空时使用文件名"
+// @Success 200 {object} common.Response "{"success":true,"msg":"","data":{"id":"","replayed":false}}"
 // @Failure 400 {object} common.Response "{"success":false,"msg":"请求参数错误","data":null}"
 // @Failure 401 {object} common.Response "{"success":false,"msg":"用户未登录","data":null}"
 // @Failure 403 {object} common.Response "{"success":false,"msg":"访问受限","data":null}"
@@ -109,12 +87,18 @@ type Client struct {
 // @Router /c/email/send [post]
 func (a *Client) HandleSentEmail(c *gin.Context) {
 	// 验证用户登录状态
-	_, err := a.GetCurrentUserID(c)
+	userID, err := a.GetCurrentUserID(c)
 	if err != nil {
 		c.JSON(http.StatusUnauthorized, common.NewResponse(common.WithMsg("用户未登录")))
 		return
 	}
 
+	idempotencyKey := strings.TrimSpace(c.GetHeader(IdempotencyKeyHeader))
+	if len(idempotencyKey) > maxIdempotencyKeyLength {
+		c.JSON(http.StatusBadRequest, common.NewResponse(common.WithMsg("幂等键过长")))
+		return
+	}
+
 	if err := c.Request.ParseMultipartForm(32 << 20); err != nil {
 		c.JSON(http.StatusBadRequest, common.NewResponse(common.WithMsg("表单解析错误")))
 		return
@@ -133,6 +117,46 @@ func (a *Client) HandleSentEmail(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, common.NewResponse(common.WithMsg("data字段格式错误")))
 		return
 	}
+	if req.From == nil {
+		c.JSON(http.StatusBadRequest, common.NewResponse(common.WithMsg("缺少发件人")))
+		return
+	}
+	if req.Auth != nil && !req.Auth.ValidMechanism() {
+		c.JSON(http.StatusBadRequest, common.NewResponse(common.WithMsg("不支持的SMTP认证方式")))
+		return
+	}
+	if !req.Server.ValidTLS() {
+		c.JSON(http.StatusBadRequest, common.NewResponse(common.WithMsg("SMTP加密设置错误")))
+		return
+	}
+	if req.Auth != nil {
+		if err := req.Auth.CheckTLS(req.Server); err != nil {
+			c.JSON(http.StatusBadRequest, common.NewResponse(common.WithMsg(err.Error())))
+			return
+		}
+	}
+	// 只能使用自己的发件账户发送，避免冒用他人账户的OAuth2令牌、DKIM和S/MIME密钥
+	if owned, err := a.ownsMailAccount(userID, req.From.Addr); err != nil {
+		log.Printf("查询发件账户失败: %v", err)
+		c.JSON(http.StatusInternalServerError, common.NewResponse(common.WithMsg(common.MsgInternalServerError)))
+		return
+	} else if !owned {
+		c.JSON(http.StatusForbidden, common.NewResponse(common.WithMsg("发件邮箱不属于当前用户")))
+		return
+	}
+	if msg, err := a.checkSMIME(userID, req); err != nil {
+		log.Printf("校验S/MIME设置失败: %v", err)
+		c.JSON(http.StatusInternalServerError, common.NewResponse(common.WithMsg(common.MsgInternalServerError)))
+		return
+	} else if msg != "" {
+		c.JSON(http.StatusBadRequest, common.NewResponse(common.WithMsg(msg)))
+		return
+	}
+
+	// 邮件唯一标识由服务端生成，忽略客户端传入的值，避免重复或冲突
+	req.ID = uuid.NewString()
+	// 租约及签名、加密所需的密钥只在下发时由服务端设置，不保存客户端传入的值
+	req.Lease, req.DKIM, req.SMIMEKeys = nil, nil, nil
 
 	// 1. 检查发件人邮箱是否在黑名单中
 	if a.isEmailBlacklisted(req.From.Addr) {
@@ -162,12 +186,15 @@ func (a *Client) HandleSentEmail(c *gin.Context) {
 		return
 	}
 
-	// 附件处理
-	files, ok := c.Request.MultipartForm.File["attachments"]
-	if ok && len(files) > 0 {
-		for _, fileHeader := range files {
+	// 附件处理：attachments中为普通附件，inline中为内嵌文件，其Content-ID取自该部分的Content-ID头部，为空时使用文件名
+	for _, field := range []string{"attachments", "inline"} {
+		for _, fileHeader := range c.Request.MultipartForm.File[field] {
 			var fileAttachment domain.FileAttachment
 			fileAttachment.Name = fileHeader.Filename
+			if field == "inline" {
+				fileAttachment.Disposition = domain.DispositionInline
+				fileAttachment.ContentID = strings.Trim(strings.TrimSpace(fileHeader.Header.Get("Content-ID")), "<>")
+			}
 
 			fileHandle, err := fileHeader.Open()
 			if err != nil {
@@ -195,27 +222,183 @@ func (a *Client) HandleSentEmail(c *gin.Context) {
 			req.Attachments = append(req.Attachments, fileAttachment)
 		}
 	}
+	if msg := checkAttachments(req.Attachments); msg != "" {
+		c.JSON(http.StatusBadRequest, common.NewResponse(common.WithMsg(msg)))
+		return
+	}
 
 	// 将请求加入队列
-	if err := EmailQueue.Enqueue(req); err != nil {
+	resp, err := a.Queue.Enqueue(req, userID, idempotencyKey)
+	if err != nil {
 		if errors.Is(err, errQueueFull) {
 			c.JSON(http.StatusTooManyRequests, common.NewResponse(common.WithMsg("队列已满")))
 			return
 		} else {
+			log.Printf("邮件入队失败: %v", err)
 			c.JSON(http.StatusInternalServerError, common.NewResponse(common.WithMsg(common.MsgInternalServerError)))
 			return
 		}
 	}
 
-	// 保存邮件记录到数据库
-	if err := a.saveEmailRecords(req); err != nil {
-		log.Printf("保存邮件记录失败: %v", err)
-		// 这里不返回错误，因为邮件已经成功加入队列
+	// 重复提交时邮件记录已在首次提交时保存
+	if !resp.Replayed {
+		// 保存邮件记录到数据库
+		if err := a.saveEmailRecords(req); err != nil {
+			log.Printf("保存邮件记录失败: %v", err)
+			// 这里不返回错误，因为邮件已经成功加入队列
+		}
+	}
+
+	c.JSON(http.StatusOK, common.NewResponse(common.WithSuccess(true), common.WithPayload(resp)))
+}
+
+// HandleCancelEmail
+// @Summary 取消发送邮件
+// @Description 取消当前用户尚未被agent获取的邮件(撤回窗口内、定时或等待重试)，邮件记录状态变为cancelled
+// @tags Client
+// @Produce json
+// @Param id path string true "邮件唯一标识"
+// @Success 200 {object} common.Response "{"success":true,"msg":"","data":null}"
+// @Failure 401 {object} common.Response "{"success":false,"msg":"用户未登录","data":null}"
+// @Failure 409 {object} common.Response "{"success":false,"msg":"邮件不存在或已开始发送","data":null}"
+// @Failure 500 {object} common.Response "{"success":false,"msg":"Internal Server Error","data":null}"
+// @Router /c/email/{id} [delete]
+func (a *Client) HandleCancelEmail(c *gin.Context) {
+	userID, err := a.GetCurrentUserID(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, common.NewResponse(common.WithMsg("用户未登录")))
+		return
+	}
+
+	id := c.Param("id")
+	if err := a.Queue.Cancel(userID, id); err != nil {
+		if errors.Is(err, errNotCancellable) {
+			c.JSON(http.StatusConflict, common.NewResponse(common.WithMsg("邮件不存在或已开始发送")))
+			return
+		}
+		log.Printf("取消邮件失败: %v", err)
+		c.JSON(http.StatusInternalServerError, common.NewResponse(common.WithMsg(common.MsgInternalServerError)))
+		return
+	}
+
+	// 记录更新失败时由状态检查任务根据队列状态补正
+	if err := a.DB.Model(&domain.EmailRecord{}).
+		Where("email_req_id = ? AND status = ?", id, "pending").
+		Update("status", "cancelled").Error; err != nil {
+		log.Printf("更新邮件记录失败: %v", err)
 	}
 
 	c.JSON(http.StatusOK, common.NewResponse(common.WithSuccess(true)))
 }
 
+// HandleListScheduledEmails
+// @Summary 定时邮件列表
+// @Description 获取当前用户尚未发出的定时邮件
+// @tags Client
+// @Produce json
+// @Success 200 {object} common.Response "{"success":true,"msg":"","data":[]}"
+// @Failure 401 {object} common.Response "{"success":false,"msg":"用户未登录","data":null}"
+// @Failure 500 {object} common.Response "{"success":false,"msg":"Internal Server Error","data":null}"
+// @Router /c/email/scheduled [get]
+func (a *Client) HandleListScheduledEmails(c *gin.Context) {
+	userID, err := a.GetCurrentUserID(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, common.NewResponse(common.WithMsg("用户未登录")))
+		return
+	}
+
+	msgs, err := a.Queue.ListScheduled(userID)
+	if err != nil {
+		log.Printf("查询定时邮件失败: %v", err)
+		c.JSON(http.StatusInternalServerError, common.NewResponse(common.WithMsg(common.MsgInternalServerError)))
+		return
+	}
+
+	c.JSON(http.StatusOK, common.NewResponse(common.WithSuccess(true), common.WithPayload(msgs)))
+}
+
+// HandleRescheduleEmail
+// @Summary 修改定时邮件发送时间
+// @Description 修改当前用户尚未发出的定时邮件的发送时间
+// @tags Client
+// @Accept json
+// @Produce json
+// @Param id path string true "邮件唯一标识"
+// @Param data body domain.RescheduleReq true "新的发送时间"
+// @Success 200 {object} common.Response "{"success":true,"msg":"","data":null}"
+// @Failure 400 {object} common.Response "{"success":false,"msg":"请求参数错误","data":null}"
+// @Failure 401 {object} common.Response "{"success":false,"msg":"用户未登录","data":null}"
+// @Failure 404 {object} common.Response "{"success":false,"msg":"定时邮件不存在或已发出","data":null}"
+// @Failure 500 {object} common.Response "{"success":false,"msg":"Internal Server Error","data":null}"
+// @Router /c/email/scheduled/{id} [put]
+func (a *Client) HandleRescheduleEmail(c *gin.Context) {
+	userID, err := a.GetCurrentUserID(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, common.NewResponse(common.WithMsg("用户未登录")))
+		return
+	}
+
+	var req domain.RescheduleReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, common.NewResponse(common.WithMsg("请求参数错误")))
+		return
+	}
+
+	if err := a.Queue.Reschedule(userID, c.Param("id"), req.SendAt); err != nil {
+		switch {
+		case errors.Is(err, errScheduleInThePast):
+			c.JSON(http.StatusBadRequest, common.NewResponse(common.WithMsg("发送时间必须晚于当前时间")))
+		case errors.Is(err, errScheduledNotFound):
+			c.JSON(http.StatusNotFound, common.NewResponse(common.WithMsg("定时邮件不存在或已发出")))
+		default:
+			log.Printf("修改定时邮件失败: %v", err)
+			c.JSON(http.StatusInternalServerError, common.NewResponse(common.WithMsg(common.MsgInternalServerError)))
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, common.NewResponse(common.WithSuccess(true)))
+}
+
+// HandleListRateLimits
+// @Summary 发送限流用量
+// @Description 获取当前用户、其发件邮箱及各SMTP服务器的限流规则和当前用量，达到限制的邮件暂不下发
+// @tags Client
+// @Produce json
+// @Success 200 {object} common.Response "{"success":true,"msg":"","data":[]}"
+// @Failure 401 {object} common.Response "{"success":false,"msg":"用户未登录","data":null}"
+// @Failure 500 {object} common.Response "{"success":false,"msg":"Internal Server Error","data":null}"
+// @Router /c/email/rate_limits [get]
+func (a *Client) HandleListRateLimits(c *gin.Context) {
+	userID, err := a.GetCurrentUserID(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, common.NewResponse(common.WithMsg("用户未登录")))
+		return
+	}
+
+	var limits []domain.RateLimit
+	if err := a.DB.
+		Where("scope = ? AND `key` = ?", domain.RateLimitScopeUser, strconv.FormatInt(userID, 10)).
+		Or("scope = ? AND `key` IN (?)", domain.RateLimitScopeAccount,
+			a.DB.Model(&domain.UserMailAccount{}).Select("email").Where("user_id = ?", userID)).
+		Or("scope = ?", domain.RateLimitScopeHost).
+		Order("scope, `key`").
+		Find(&limits).Error; err != nil {
+		log.Printf("查询限流规则失败: %v", err)
+		c.JSON(http.StatusInternalServerError, common.NewResponse(common.WithMsg(common.MsgInternalServerError)))
+		return
+	}
+
+	usages, err := a.Queue.RateLimitUsages(limits)
+	if err != nil {
+		log.Printf("统计限流用量失败: %v", err)
+		c.JSON(http.StatusInternalServerError, common.NewResponse(common.WithMsg(common.MsgInternalServerError)))
+		return
+	}
+
+	c.JSON(http.StatusOK, common.NewResponse(common.WithSuccess(true), common.WithPayload(usages)))
+}
+
 // HandleVerifyEmail
 // @Summary 邮件发送结果确认
 // @Description 处理来自Client的邮件确认请求
@@ -342,7 +525,11 @@ func (a *Client) HandleListEmailAccounts(c *gin.Context) {
 		return
 	}
 
-	c.JSON(http.StatusOK, common.NewResponse(common.WithSuccess(true), common.WithPayload(accounts)))
+	resp := make([]domain.UserMailAccountResp, len(accounts))
+	for i, account := range accounts {
+		resp[i] = domain.NewUserMailAccountResp(account)
+	}
+	c.JSON(http.StatusOK, common.NewResponse(common.WithSuccess(true), common.WithPayload(resp)))
 }
 
 // HandleCreateEmailAccount
@@ -372,6 +559,20 @@ func (a *Client) HandleCreateEmailAccount(c *gin.Context) {
 
 	account.UserID = userID
 
+	if account.OAuthTokenURL != "" {
+		if err := a.Queue.validateOAuthTokenURL(account.OAuthTokenURL); err != nil {
+			c.JSON(http.StatusBadRequest, common.NewResponse(common.WithMsg("OAuth2令牌端点无效: "+err.Error())))
+			return
+		}
+	}
+
+	if account.SMIMECert != "" || account.SMIMEKey != "" {
+		if err := validateSMIMEKeyPair(account.SMIMECert, account.SMIMEKey); err != nil {
+			c.JSON(http.StatusBadRequest, common.NewResponse(common.WithMsg("S/MIME证书或私钥无效: "+err.Error())))
+			return
+		}
+	}
+
 	// 检查邮箱唯一性
 	if err := a.DB.Where("email = ?", account.Email).First(&domain.UserMailAccount{}).Error; err == nil {
 		c.JSON(http.StatusConflict, common.NewResponse(common.WithMsg("邮箱已存在")))
@@ -426,6 +627,28 @@ func (a *Client) HandleUpdateEmailAccount(c *gin.Context) {
 		return
 	}
 
+	if updateData.OAuthTokenURL != "" {
+		if err := a.Queue.validateOAuthTokenURL(updateData.OAuthTokenURL); err != nil {
+			c.JSON(http.StatusBadRequest, common.NewResponse(common.WithMsg("OAuth2令牌端点无效: "+err.Error())))
+			return
+		}
+	}
+
+	// 只更新证书或私钥之一时与已保存的另一半校验
+	if updateData.SMIMECert != "" || updateData.SMIMEKey != "" {
+		cert, key := updateData.SMIMECert, updateData.SMIMEKey
+		if cert == "" {
+			cert = account.SMIMECert
+		}
+		if key == "" {
+			key = account.SMIMEKey
+		}
+		if err := validateSMIMEKeyPair(cert, key); err != nil {
+			c.JSON(http.StatusBadRequest, common.NewResponse(common.WithMsg("S/MIME证书或私钥无效: "+err.Error())))
+			return
+		}
+	}
+
 	// 检查邮箱唯一性（排除当前记录）
 	if updateData.Email != account.Email {
 		if err := a.DB.Where("email = ? AND id != ?", updateData.Email, account.ID).First(&domain.UserMailAccount{}).Error; err == nil {
@@ -434,6 +657,18 @@ func (a *Client) HandleUpdateEmailAccount(c *gin.Context) {
 		}
 	}
 
+	// OAuth2配置变化后缓存的访问令牌不再可用
+	if updateData.OAuthTokenURL != "" || updateData.OAuthClientID != "" || updateData.OAuthClientSecret != "" ||
+		updateData.OAuthScope != "" || updateData.OAuthRefreshToken != "" {
+		if err := a.DB.Model(&account).Updates(map[string]interface{}{
+			"oauth_access_token": nil,
+			"oauth_expires_at":   nil,
+		}).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, common.NewResponse(common.WithMsg("更新失败")))
+			return
+		}
+	}
+
 	if err := a.DB.Model(&account).Updates(updateData).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, common.NewResponse(common.WithMsg("更新失败")))
 		return
@@ -595,6 +830,60 @@ func (a *Client) saveEmailRecords(req domain.EmailReq) error {
 	return nil
 }
 
+// SyncEmailRecords 邮件重新入队时，已有记录的收件人恢复为pending，新增的收件人补充记录
+func (a *Client) SyncEmailRecords(req domain.EmailReq) error {
+	if req.From == nil {
+		return fmt.Errorf("email request %s has no sender", req.ID)
+	}
+
+	var existing []domain.EmailRecord
+	if err := a.DB.Where("email_req_id = ?", req.ID).Find(&existing).Error; err != nil {
+		return err
+	}
+	recorded := make(map[string]int64, len(existing))
+	for _, record := range existing {
+		recorded[strings.ToLower(record.ToEmail)] = record.ID
+	}
+
+	fromUserID, err := a.getUserIDByEmail(req.From.Addr)
+	if err != nil {
+		return fmt.Errorf("failed to get sender user ID for email %s: %v", req.From.Addr, err)
+	}
+
+	var resetIDs []int64
+	var records []domain.EmailRecord
+	recipients := []struct {
+		recipientType string
+		addrs         []domain.EmailAddress
+	}{{"to", req.To}, {"cc", req.CC}, {"bcc", req.BCC}}
+	for _, group := range recipients {
+		recipientType := group.recipientType
+		for _, addr := range group.addrs {
+			if id, ok := recorded[strings.ToLower(addr.Addr)]; ok {
+				resetIDs = append(resetIDs, id)
+				continue
+			}
+			if err := a.processRecipient(fromUserID, req, addr.Addr, recipientType, &records); err != nil {
+				log.Printf("Failed to process %s recipient %s: %v", recipientType, addr.Addr, err)
+			}
+		}
+	}
+
+	return a.DB.Transaction(func(tx *gorm.DB) error {
+		if len(resetIDs) > 0 {
+			if err := tx.Model(&domain.EmailRecord{}).
+				Where("id IN ?", resetIDs).
+				Updates(map[string]interface{}{"status": "pending", "retry_count": 0}).Error; err != nil {
+				return err
+			}
+		}
+		if len(records) > 0 {
+			return tx.Create(&records).Error
+		}
+		return nil
+	})
+}
+
 // processRecipient 处理单个收件人
 func (a *Client) processRecipient(
 	fromUserID int64,
@@ -629,6 +918,17 @@ func (a *Client) processRecipient(
 	return nil
 }
 
+// ownsMailAccount 发件地址是否为用户userID的邮箱账户
+func (a *Client) ownsMailAccount(userID int64, addr string) (bool, error) {
+	var count int64
+	if err := a.DB.Model(&domain.UserMailAccount{}).
+		Where("user_id = ? AND email = ?", userID, addr).
+		Count(&count).Error; err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
 // isEmailBlacklisted 检查邮箱是否在黑名单中
 func (a *Client) isEmailBlacklisted(email string) bool {
 	var count int64
@@ -660,3 +960,26 @@ func (a *Client) getUserIDByEmail(email string) (int64, error) {
 
 	return userID, nil
 }
+
+// checkAttachments 校验附件的Content-Disposition和Content-ID，同一邮件中内嵌文件的Content-ID不能重复。不满足时返回提示信息
+func checkAttachments(files []domain.FileAttachment) string {
+	contentIDs := make(map[string]bool)
+	for i := range files {
+		file := &files[i]
+		if !file.ValidDisposition() {
+			return fmt.Sprintf("附件 %s 的disposition或content_id错误", file.Name)
+		}
+		if !strings.EqualFold(file.Disposition, domain.DispositionInline) {
+			continue
+		}
+		contentID := file.ContentID
+		if contentID == "" {
+			contentID = file.Name
+		}
+		if contentIDs[strings.ToLower(contentID)] {
+			return fmt.Sprintf("内嵌文件的Content-ID重复: %s", contentID)
+		}
+		contentIDs[strings.ToLower(contentID)] = true
+	}
+	return ""
+}
@@ -0,0 +1,156 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm/clause"
+	"msps/internal/app/model/common"
+	"msps/internal/app/model/domain"
+)
+
+// HandleListSMIMECertificates
+// @Summary 收件人S/MIME证书列表
+// @Description 获取当前用户保存的收件人证书，用于加密发给这些收件人的邮件
+// @tags Client
+// @Produce json
+// @Success 200 {object} common.Response "{"success":true,"msg":"","data":[]}"
+// @Failure 401 {object} common.Response "{"success":false,"msg":"用户未登录","data":null}"
+// @Failure 500 {object} common.Response "{"success":false,"msg":"Internal Server Error","data":null}"
+// @Router /c/email/smime_certs [get]
+func (a *Client) HandleListSMIMECertificates(c *gin.Context) {
+	userID, err := a.GetCurrentUserID(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, common.NewResponse(common.WithMsg("用户未登录")))
+		return
+	}
+
+	var certs []domain.SMIMECertificate
+	if err := a.DB.Where("user_id = ?", userID).Order("email").Find(&certs).Error; err != nil {
+		log.Printf("查询收件人证书失败: %v", err)
+		c.JSON(http.StatusInternalServerError, common.NewResponse(common.WithMsg(common.MsgInternalServerError)))
+		return
+	}
+
+	c.JSON(http.StatusOK, common.NewResponse(common.WithSuccess(true), common.WithPayload(certs)))
+}
+
+// HandleSaveSMIMECertificate
+// @Summary 上传收件人S/MIME证书
+// @Description 保存收件人的RSA证书，同一收件人已有的证书会被覆盖
+// @tags Client
+// @Accept json
+// @Produce json
+// @Param data body domain.SMIMECertificateReq true "收件人证书"
+// @Success 200 {object} common.Response "{"success":true,"msg":"","data":{}}"
+// @Failure 400 {object} common.Response "{"success":false,"msg":"请求参数错误","data":null}"
+// @Failure 401 {object} common.Response "{"success":false,"msg":"用户未登录","data":null}"
+// @Failure 500 {object} common.Response "{"success":false,"msg":"Internal Server Error","data":null}"
+// @Router /c/email/smime_certs [put]
+func (a *Client) HandleSaveSMIMECertificate(c *gin.Context) {
+	userID, err := a.GetCurrentUserID(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, common.NewResponse(common.WithMsg("用户未登录")))
+		return
+	}
+
+	var req domain.SMIMECertificateReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, common.NewResponse(common.WithMsg(common.MsgInvalidParam)))
+		return
+	}
+
+	cert, err := validateRecipientCertificate(req.Certificate)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, common.NewResponse(common.WithMsg("证书无效: "+err.Error())))
+		return
+	}
+
+	// 证书中带有邮箱地址时，收件人须与其中之一相同
+	email := strings.ToLower(req.Email)
+	if email == "" {
+		if len(cert.EmailAddresses) == 0 {
+			c.JSON(http.StatusBadRequest, common.NewResponse(common.WithMsg("证书中没有邮箱地址，请指定收件人")))
+			return
+		}
+		email = strings.ToLower(cert.EmailAddresses[0])
+	} else if len(cert.EmailAddresses) > 0 {
+		matched := false
+		for _, addr := range cert.EmailAddresses {
+			if strings.EqualFold(addr, email) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			c.JSON(http.StatusBadRequest, common.NewResponse(common.WithMsg("收件人与证书中的邮箱地址不符")))
+			return
+		}
+	}
+
+	record := domain.SMIMECertificate{
+		UserID:      userID,
+		Email:       email,
+		Subject:     truncateString(cert.Subject.String(), 255),
+		Certificate: req.Certificate,
+		NotAfter:    cert.NotAfter,
+	}
+	if err := a.DB.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}, {Name: "email"}},
+		DoUpdates: clause.AssignmentColumns([]string{"subject", "certificate", "not_after"}),
+	}).Create(&record).Error; err != nil {
+		log.Printf("保存收件人证书失败: %v", err)
+		c.JSON(http.StatusInternalServerError, common.NewResponse(common.WithMsg(common.MsgInternalServerError)))
+		return
+	}
+	// 覆盖已有证书时插入返回的ID不可靠，重新读取
+	if err := a.DB.Where("user_id = ? AND email = ?", userID, email).First(&record).Error; err != nil {
+		log.Printf("读取收件人证书失败: %v", err)
+		c.JSON(http.StatusInternalServerError, common.NewResponse(common.WithMsg(common.MsgInternalServerError)))
+		return
+	}
+
+	c.JSON(http.StatusOK, common.NewResponse(common.WithSuccess(true), common.WithPayload(record)))
+}
+
+// HandleDeleteSMIMECertificate
+// @Summary 删除收件人S/MIME证书
+// @Description 删除后无法再向该收件人发送加密邮件
+// @tags Client
+// @Produce json
+// @Param id path int true "证书ID"
+// @Success 200 {object} common.Response "{"success":true,"msg":"","data":null}"
+// @Failure 400 {object} common.Response "{"success":false,"msg":"请求参数错误","data":null}"
+// @Failure 401 {object} common.Response "{"success":false,"msg":"用户未登录","data":null}"
+// @Failure 404 {object} common.Response "{"success":false,"msg":"证书不存在","data":null}"
+// @Failure 500 {object} common.Response "{"success":false,"msg":"Internal Server Error","data":null}"
+// @Router /c/email/smime_certs/{id} [delete]
+func (a *Client) HandleDeleteSMIMECertificate(c *gin.Context) {
+	userID, err := a.GetCurrentUserID(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, common.NewResponse(common.WithMsg("用户未登录")))
+		return
+	}
+
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, common.NewResponse(common.WithMsg(common.MsgInvalidParam)))
+		return
+	}
+
+	result := a.DB.Where("id = ? AND user_id = ?", id, userID).Delete(&domain.SMIMECertificate{})
+	if result.Error != nil {
+		log.Printf("删除收件人证书失败: %v", result.Error)
+		c.JSON(http.StatusInternalServerError, common.NewResponse(common.WithMsg(common.MsgInternalServerError)))
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, common.NewResponse(common.WithMsg("证书不存在")))
+		return
+	}
+
+	c.JSON(http.StatusOK, common.NewResponse(common.WithSuccess(true)))
+}
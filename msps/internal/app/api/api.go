@@ -1,6 +1,13 @@
 package api
 
-import "github.com/google/wire"
+import (
+	"time"
+
+	"github.com/google/wire"
+	"gorm.io/gorm"
+
+	"msps/internal/app/config"
+)
 
 var ProviderSet = wire.NewSet(
 	ProvideAgentSet,
@@ -8,14 +15,27 @@ var ProviderSet = wire.NewSet(
 	ProvideMailQueue,
 )
 
-func ProvideAgentSet() *Agent {
-	return &Agent{}
+func ProvideAgentSet(db *gorm.DB, queue *MailQueue) *Agent {
+	return &Agent{DB: db, Queue: queue}
 }
 
-func ProvideClientSet() *Client {
-	return &Client{}
+func ProvideClientSet(db *gorm.DB, queue *MailQueue) *Client {
+	return &Client{DB: db, Queue: queue}
 }
 
-func ProvideMailQueue() *MailQueue {
-	return NewMailQueue(defaultQueueCapacity)
+func ProvideMailQueue(db *gorm.DB) *MailQueue {
+	cfg := config.GlobalConfig()
+	leaseTimeout := time.Duration(cfg.LeaseTimeout) * time.Second
+	priorityAging := time.Duration(cfg.PriorityAging) * time.Second
+	idempotency := time.Duration(cfg.IdempotencyWindow) * time.Second
+	undoSend := time.Duration(cfg.UndoSendWindow) * time.Second
+	retry := RetryPolicy{
+		MaxAttempts: cfg.RetryMaxAttempts,
+		BaseDelay:   time.Duration(cfg.RetryBaseDelay) * time.Second,
+		MaxDelay:    time.Duration(cfg.RetryMaxDelay) * time.Second,
+	}
+	queue := NewMailQueue(db, defaultQueueCapacity, leaseTimeout, priorityAging, idempotency, undoSend, retry)
+	queue.SetOAuthTrustedHosts(cfg.OAuthTrustedHosts)
+	go queue.RunOAuthRefresher()
+	return queue
 }
@@ -0,0 +1,190 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"msps/internal/app/model/domain"
+)
+
+// ErrDeadLetterNotFound 死信不存在或已被处理
+var ErrDeadLetterNotFound = errors.New("dead letter not found or already resolved")
+
+// deadLetter 将发送失败的邮件连同最后一次错误转入死信
+func (q *MailQueue) deadLetter(tx *gorm.DB, msg domain.OutboundMessage, reason, lastError string, attempts int) error {
+	return tx.Create(&domain.DeadLetter{
+		OutboundMessageID: msg.ID,
+		EmailReqID:        msg.EmailReqID,
+		UserID:            msg.UserID,
+		FromAddr:          msg.FromAddr,
+		Subject:           msg.Subject,
+		Payload:           msg.Payload,
+		Reason:            reason,
+		LastError:         truncateString(lastError, maxLastErrorLength),
+		Attempts:          attempts,
+		Status:            domain.DeadLetterStatusPending,
+	}).Error
+}
+
+// expireLeases 多次租约到期仍未收到确认的邮件(如agent反复崩溃)不再重新分配，转入死信
+func (q *MailQueue) expireLeases(tx *gorm.DB, now time.Time) error {
+	var msgs []domain.OutboundMessage
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+		Where("status = ? AND lease_expires_at < ? AND dispatch_count >= ?",
+			domain.OutboundStatusDispatched, now, q.Retry.MaxAttempts).
+		Find(&msgs).Error; err != nil {
+		return err
+	}
+
+	for _, msg := range msgs {
+		lastError := fmt.Sprintf("lease expired %d times without acknowledgement", msg.DispatchCount)
+		if err := tx.Model(&msg).Updates(map[string]interface{}{
+			"status":           domain.OutboundStatusFailed,
+			"lease_expires_at": nil,
+			"last_error":       lastError,
+			"completed_at":     now,
+		}).Error; err != nil {
+			return err
+		}
+		if err := releaseDispatch(tx, msg.LeaseID); err != nil {
+			return err
+		}
+
+		if err := q.deadLetter(tx, msg, domain.DeadLetterReasonLeaseExhausted, lastError, msg.Attempts); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// UpdateDeadLetter 修改待处理死信的收件人或发送凭证
+func (q *MailQueue) UpdateDeadLetter(id int64, update domain.DeadLetterUpdateReq) error {
+	return q.DB.Transaction(func(tx *gorm.DB) error {
+		letter, req, err := lockPendingDeadLetter(tx, id)
+		if err != nil {
+			return err
+		}
+
+		if update.To != nil {
+			req.To = update.To
+		}
+		if update.CC != nil {
+			req.CC = update.CC
+		}
+		if update.BCC != nil {
+			req.BCC = update.BCC
+		}
+		if update.Server != nil {
+			req.Server = *update.Server
+		}
+		if update.Auth != nil {
+			req.Auth = update.Auth
+		}
+		if req.Auth != nil {
+			if err := req.Auth.CheckTLS(req.Server); err != nil {
+				return err
+			}
+		}
+
+		payload, err := json.Marshal(req)
+		if err != nil {
+			return fmt.Errorf("failed to marshal email request: %w", err)
+		}
+
+		return tx.Model(&letter).Update("payload", string(payload)).Error
+	})
+}
+
+// RequeueDeadLetter 使用死信中(可能已修改)的请求重新入队，尝试次数清零
+func (q *MailQueue) RequeueDeadLetter(id int64) (domain.EmailReq, error) {
+	var req domain.EmailReq
+
+	err := q.DB.Transaction(func(tx *gorm.DB) error {
+		letter, pending, err := lockPendingDeadLetter(tx, id)
+		if err != nil {
+			return err
+		}
+		req = pending
+		req.Lease = nil
+		req.SendAt = nil
+		now := time.Now()
+
+		payload, err := json.Marshal(req)
+		if err != nil {
+			return fmt.Errorf("failed to marshal email request: %w", err)
+		}
+
+		if err := tx.Model(&domain.OutboundMessage{}).
+			Where("id = ?", letter.OutboundMessageID).
+			Updates(map[string]interface{}{
+				"payload":          string(payload),
+				"smtp_host":        smtpHost(req),
+				"status":           domain.OutboundStatusQueued,
+				"lease_id":         nil,
+				"lease_expires_at": nil,
+				"agent_id":         nil,
+				"dispatch_count":   0,
+				"attempts":         0,
+				"available_at":     now, // 优先级从重新入队时开始提升
+				"send_at":          nil,
+				"last_error":       nil,
+				"completed_at":     nil,
+			}).Error; err != nil {
+			return err
+		}
+
+		return tx.Model(&letter).Updates(map[string]interface{}{
+			"status":      domain.DeadLetterStatusRequeued,
+			"resolved_at": now,
+		}).Error
+	})
+	if err != nil {
+		return domain.EmailReq{}, err
+	}
+
+	// 转入死信时的失败结果已不再有效，否则邮件记录会在重新发送前再次被标记为失败
+	VerifyMap.DeleteEmailVerifyInfo(req.ID)
+
+	q.notifier.broadcast()
+	return req, nil
+}
+
+// DiscardDeadLetter 丢弃待处理的死信
+func (q *MailQueue) DiscardDeadLetter(id int64) error {
+	result := q.DB.Model(&domain.DeadLetter{}).
+		Where("id = ? AND status = ?", id, domain.DeadLetterStatusPending).
+		Updates(map[string]interface{}{
+			"status":      domain.DeadLetterStatusDiscarded,
+			"resolved_at": time.Now(),
+		})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrDeadLetterNotFound
+	}
+	return nil
+}
+
+// lockPendingDeadLetter 锁定待处理的死信并解析其中的邮件请求
+func lockPendingDeadLetter(tx *gorm.DB, id int64) (domain.DeadLetter, domain.EmailReq, error) {
+	var letter domain.DeadLetter
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("id = ? AND status = ?", id, domain.DeadLetterStatusPending).
+		First(&letter).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return letter, domain.EmailReq{}, ErrDeadLetterNotFound
+		}
+		return letter, domain.EmailReq{}, err
+	}
+
+	var req domain.EmailReq
+	if err := json.Unmarshal([]byte(letter.Payload), &req); err != nil {
+		return letter, req, fmt.Errorf("failed to unmarshal dead letter %d: %w", letter.ID, err)
+	}
+	return letter, req, nil
+}
@@ -0,0 +1,86 @@
+package api
+
+import (
+	"errors"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"msps/internal/app/model/domain"
+)
+
+// applyDKIMKeys 为下发的邮件附上发件域名最新启用的DKIM密钥，由agent在发送前签名，userIDs与reqs一一对应。
+// 只有发件地址是入队用户自己的邮箱账户、且管理员已授权该用户以发件域名签名时才签名，
+// 邮箱账户由用户自行添加，避免冒用他人域名得到有效的DKIM签名；
+// 密钥只随本次下发返回，不写入队列中的邮件内容；发件域名没有启用的密钥时不签名。
+func (q *MailQueue) applyDKIMKeys(reqs []domain.EmailReq, userIDs []int64) {
+	type dkimGrantKey struct {
+		userID int64
+		domain string
+	}
+	grants := make(map[dkimGrantKey]bool)
+	signers := make(map[string]*domain.DKIMSigner)
+	for i := range reqs {
+		reqs[i].DKIM = nil
+		if reqs[i].From == nil {
+			continue
+		}
+
+		senderDomain := addrDomain(reqs[i].From.Addr)
+		if senderDomain == "" {
+			continue
+		}
+
+		var owned int64
+		if err := q.DB.Model(&domain.UserMailAccount{}).
+			Where("user_id = ? AND email = ?", userIDs[i], reqs[i].From.Addr).
+			Count(&owned).Error; err != nil {
+			log.Printf("查询 %s 的发件账户失败: %v", reqs[i].From.Addr, err)
+			continue
+		}
+		if owned == 0 {
+			continue
+		}
+
+		grant := dkimGrantKey{userID: userIDs[i], domain: senderDomain}
+		granted, ok := grants[grant]
+		if !ok {
+			var count int64
+			if err := q.DB.Model(&domain.DKIMGrant{}).
+				Where("domain = ? AND user_id = ?", senderDomain, userIDs[i]).
+				Count(&count).Error; err != nil {
+				log.Printf("查询 %s 的DKIM签名授权失败: %v", senderDomain, err)
+				continue
+			}
+			granted = count > 0
+			grants[grant] = granted
+		}
+		if !granted {
+			continue
+		}
+
+		signer, ok := signers[senderDomain]
+		if !ok {
+			var key domain.DKIMKey
+			err := q.DB.Where("domain = ? AND status = ?", senderDomain, domain.DKIMKeyStatusActive).
+				Order("id DESC").
+				First(&key).Error
+			if err == nil {
+				signer = &domain.DKIMSigner{Domain: key.Domain, Selector: key.Selector, PrivateKey: key.PrivateKey}
+			} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+				log.Printf("查询 %s 的DKIM密钥失败: %v", senderDomain, err)
+			}
+			signers[senderDomain] = signer
+		}
+		reqs[i].DKIM = signer
+	}
+}
+
+// addrDomain 邮箱地址的域名部分(小写)
+func addrDomain(addr string) string {
+	at := strings.LastIndex(addr, "@")
+	if at < 0 {
+		return ""
+	}
+	return strings.ToLower(addr[at+1:])
+}
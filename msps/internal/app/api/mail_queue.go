@@ -0,0 +1,711 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"msps/internal/app/model/domain"
+)
+
+const (
+	defaultLeaseTimeout     = 60 * time.Second
+	defaultPriorityAging    = 5 * time.Minute
+	defaultIdempotency      = 24 * time.Hour
+	defaultRetryMaxAttempts = 5
+	defaultRetryBaseDelay   = 30 * time.Second
+	defaultRetryMaxDelay    = time.Hour
+	maxLastErrorLength      = 512
+	// waitRecheckInterval 长轮询期间重新检查队列的间隔，用于发现定时、重试及租约过期的邮件
+	waitRecheckInterval = 5 * time.Second
+)
+
+var (
+	errQueueFull         = errors.New("queue is full")
+	errQueueEmpty        = errors.New("queue is empty")
+	errLeaseNotFound     = errors.New("lease not found or superseded")
+	errScheduledNotFound = errors.New("scheduled message not found")
+	errScheduleInThePast = errors.New("send time is in the past")
+	errNotCancellable    = errors.New("message not found or already picked up")
+)
+
+// RetryPolicy 临时失败的重试策略，等待时间按指数增长
+type RetryPolicy struct {
+	MaxAttempts int           // 最多尝试次数(含首次发送)
+	BaseDelay   time.Duration // 首次重试等待时间
+	MaxDelay    time.Duration // 等待时间上限
+}
+
+// Backoff 第attempt次尝试失败后，距下一次尝试的等待时间
+func (p RetryPolicy) Backoff(attempt int) time.Duration {
+	delay := p.BaseDelay
+	for i := 1; i < attempt && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	return delay
+}
+
+// AckResult 确认发送结果后的队列状态
+type AckResult struct {
+	Attempt     int        // 本次为第几次尝试
+	AgentID     string     // 执行本次投递的agent
+	Final       bool       // 是否已是最终结果，false表示已安排重试
+	NextAttempt *time.Time // 安排重试时下一次可被获取的时间
+}
+
+// MailQueue 基于数据库的持久化邮件队列，服务重启后队列中的邮件不会丢失
+type MailQueue struct {
+	DB            *gorm.DB
+	QueueCapacity int
+	LeaseTimeout  time.Duration // 租约有效期，超时未确认的邮件重新变为可获取
+	PriorityAging time.Duration // 邮件每等待一个周期优先级提升一级，避免低优先级邮件饿死
+	Idempotency   time.Duration // 幂等键有效期
+	UndoSend      time.Duration // 撤回窗口，邮件入队后至少经过该时间才会下发给agent
+	Retry         RetryPolicy
+
+	notifier     queueNotifier
+	lastPrune    atomic.Int64      // 最近一次清理下发记录的时间(Unix秒)
+	oauthTrusted oauthTrustedHosts // 受信任的令牌端点主机
+	oauthClient  *http.Client      // 请求令牌端点的客户端
+	oauthLocks   keyedMutex        // 按发件账户串行刷新OAuth2访问令牌，避免并发下发时重复请求令牌端点
+}
+
+// queueNotifier 有新邮件入队时唤醒所有等待中的agent
+type queueNotifier struct {
+	mu sync.Mutex
+	ch chan struct{}
+}
+
+// wait 返回在下一次入队时关闭的channel
+func (n *queueNotifier) wait() <-chan struct{} {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.ch == nil {
+		n.ch = make(chan struct{})
+	}
+	return n.ch
+}
+
+func (n *queueNotifier) broadcast() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.ch != nil {
+		close(n.ch)
+		n.ch = nil
+	}
+}
+
+func NewMailQueue(db *gorm.DB, capacity int, leaseTimeout, priorityAging, idempotency, undoSend time.Duration, retry RetryPolicy) *MailQueue {
+	if leaseTimeout <= 0 {
+		leaseTimeout = defaultLeaseTimeout
+	}
+	if priorityAging <= 0 {
+		priorityAging = defaultPriorityAging
+	}
+	if idempotency <= 0 {
+		idempotency = defaultIdempotency
+	}
+	if retry.MaxAttempts <= 0 {
+		retry.MaxAttempts = defaultRetryMaxAttempts
+	}
+	if retry.BaseDelay <= 0 {
+		retry.BaseDelay = defaultRetryBaseDelay
+	}
+	if retry.MaxDelay <= 0 {
+		retry.MaxDelay = defaultRetryMaxDelay
+	}
+
+	return &MailQueue{
+		DB:            db,
+		QueueCapacity: capacity,
+		LeaseTimeout:  leaseTimeout,
+		PriorityAging: priorityAging,
+		Idempotency:   idempotency,
+		UndoSend:      undoSend,
+		Retry:         retry,
+		oauthClient:   newOAuthHTTPClient(nil),
+	}
+}
+
+// Enqueue 将邮件请求写入队列表，设置了定时发送时间的邮件在该时间之前不会被agent获取，
+// 配置了撤回窗口时，邮件在窗口结束前同样不会被获取。
+// 携带幂等键时，有效期内同一用户重复提交不会再次入队，返回首次提交的邮件标识
+func (q *MailQueue) Enqueue(req domain.EmailReq, userID int64, idempotencyKey string) (domain.EmailSendResp, error) {
+	resp := domain.EmailSendResp{ID: req.ID}
+
+	payload, err := json.Marshal(req)
+	if err != nil {
+		return resp, fmt.Errorf("failed to marshal email request: %w", err)
+	}
+
+	msg := domain.OutboundMessage{
+		EmailReqID: req.ID,
+		Payload:    string(payload),
+		UserID:     userID,
+		Subject:    truncateString(req.Subject, 255),
+		SmtpHost:   smtpHost(req),
+		Priority:   domain.OutboundPriority(req.Priority),
+		Status:     domain.OutboundStatusQueued,
+	}
+	if req.From != nil {
+		msg.FromAddr = req.From.Addr
+	}
+	if msg.Pool, err = q.resolvePool(req); err != nil {
+		return resp, err
+	}
+	now := time.Now()
+	if req.SendAt != nil && req.SendAt.After(now) {
+		msg.SendAt = req.SendAt
+		msg.AvailableAt = req.SendAt
+	}
+	if q.UndoSend > 0 {
+		holdUntil := now.Add(q.UndoSend)
+		if msg.AvailableAt == nil || msg.AvailableAt.Before(holdUntil) {
+			msg.AvailableAt = &holdUntil
+		}
+		resp.HoldUntil = msg.AvailableAt
+	}
+
+	err = q.DB.Transaction(func(tx *gorm.DB) error {
+		if idempotencyKey != "" {
+			original, err := q.claimIdempotencyKey(tx, userID, idempotencyKey, req.ID)
+			if err != nil {
+				return err
+			}
+			if original != "" {
+				resp = domain.EmailSendResp{ID: original, Replayed: true}
+				return nil
+			}
+		}
+
+		var count int64
+		if err := tx.Model(&domain.OutboundMessage{}).
+			Where("status = ?", domain.OutboundStatusQueued).
+			Count(&count).Error; err != nil {
+			return err
+		}
+
+		if count >= int64(q.QueueCapacity) {
+			return errQueueFull
+		}
+
+		return tx.Create(&msg).Error
+	})
+	if err != nil {
+		return domain.EmailSendResp{}, err
+	}
+
+	if !resp.Replayed {
+		if msg.AvailableAt == nil {
+			q.notifier.broadcast()
+		} else if delay := time.Until(*msg.AvailableAt); delay <= q.UndoSend {
+			// 撤回窗口结束时唤醒等待中的agent，不必等到下一次定期检查
+			time.AfterFunc(delay, q.notifier.broadcast)
+		}
+	}
+	return resp, nil
+}
+
+// Cancel 取消用户尚未被agent获取的邮件，包括撤回窗口内、定时及等待重试的邮件
+func (q *MailQueue) Cancel(userID int64, emailReqID string) error {
+	result := q.DB.Model(&domain.OutboundMessage{}).
+		Where("user_id = ? AND email_req_id = ? AND status = ?", userID, emailReqID, domain.OutboundStatusQueued).
+		Updates(map[string]interface{}{
+			"status":       domain.OutboundStatusCancelled,
+			"available_at": nil,
+			"completed_at": time.Now(),
+		})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errNotCancellable
+	}
+	return nil
+}
+
+// claimIdempotencyKey 登记幂等键，键在有效期内已被使用时返回首次提交的邮件标识。
+// 幂等键与邮件在同一事务中写入，入队失败时键不会被占用；并发提交时后者等待前者提交后读取其结果
+func (q *MailQueue) claimIdempotencyKey(tx *gorm.DB, userID int64, key, emailReqID string) (string, error) {
+	// 顺带清理该用户已过期的幂等键
+	if err := tx.Where("user_id = ? AND created_at < ?", userID, time.Now().Add(-q.Idempotency)).
+		Delete(&domain.IdempotencyKey{}).Error; err != nil {
+		return "", err
+	}
+
+	result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&domain.IdempotencyKey{
+		UserID:     userID,
+		Key:        key,
+		EmailReqID: emailReqID,
+	})
+	if result.Error != nil {
+		return "", result.Error
+	}
+	if result.RowsAffected > 0 {
+		return "", nil
+	}
+
+	var existing domain.IdempotencyKey
+	if err := tx.Clauses(clause.Locking{Strength: "SHARE"}).
+		Where("user_id = ? AND `key` = ?", userID, key).
+		First(&existing).Error; err != nil {
+		return "", err
+	}
+	return existing.EmailReqID, nil
+}
+
+// resolvePool 邮件指定的池优先，否则使用发件账户绑定的池
+func (q *MailQueue) resolvePool(req domain.EmailReq) (string, error) {
+	if req.Pool != "" || req.From == nil {
+		return req.Pool, nil
+	}
+
+	var account domain.UserMailAccount
+	if err := q.DB.Select("pool").Where("email = ?", req.From.Addr).First(&account).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return "", nil
+		}
+		return "", err
+	}
+	return account.Pool, nil
+}
+
+// ListScheduled 查询用户尚未发出的定时邮件
+func (q *MailQueue) ListScheduled(userID int64) ([]domain.OutboundMessage, error) {
+	var msgs []domain.OutboundMessage
+	if err := q.DB.Omit("payload").
+		Where("user_id = ? AND status = ? AND send_at IS NOT NULL AND attempts = 0",
+			userID, domain.OutboundStatusQueued).
+		Order("send_at").
+		Find(&msgs).Error; err != nil {
+		return nil, err
+	}
+	return msgs, nil
+}
+
+// Reschedule 修改用户定时邮件的发送时间，仅对尚未被agent获取的定时邮件有效
+func (q *MailQueue) Reschedule(userID int64, emailReqID string, sendAt time.Time) error {
+	if !sendAt.After(time.Now()) {
+		return errScheduleInThePast
+	}
+
+	return q.DB.Transaction(func(tx *gorm.DB) error {
+		var msg domain.OutboundMessage
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("user_id = ? AND email_req_id = ? AND status = ? AND send_at IS NOT NULL AND attempts = 0",
+				userID, emailReqID, domain.OutboundStatusQueued).
+			First(&msg).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return errScheduledNotFound
+			}
+			return err
+		}
+
+		var req domain.EmailReq
+		if err := json.Unmarshal([]byte(msg.Payload), &req); err != nil {
+			return fmt.Errorf("failed to unmarshal queued message %d: %w", msg.ID, err)
+		}
+		req.SendAt = &sendAt
+
+		payload, err := json.Marshal(req)
+		if err != nil {
+			return fmt.Errorf("failed to marshal email request: %w", err)
+		}
+
+		return tx.Model(&msg).Updates(map[string]interface{}{
+			"payload":      string(payload),
+			"send_at":      sendAt,
+			"available_at": sendAt,
+		}).Error
+	})
+}
+
+// Dequeue 按优先级为agentID取出至多limit封邮件并分别生成租约，使用行锁避免多个agent取到同一封邮件。
+// 指定了池的邮件只分配给心跳中带有该标签的agent。
+// 邮件每等待PriorityAging提升一级优先级，同级按入队顺序；租约已过期但未确认的邮件视为重新入队，可再次被获取，
+// 被获取次数达到最大尝试次数后转入死信。
+// 发件账户、用户或SMTP服务器达到限流时，相应邮件保留在队列中，不会下发。
+// 发件账户配置了OAuth2时，下发的邮件使用新换取的访问令牌认证；发件地址属于入队用户且域名配置了DKIM密钥时附上签名密钥；
+// 要求S/MIME的邮件附上所需的证书和私钥。
+func (q *MailQueue) Dequeue(agentID string, limit int) ([]domain.EmailReq, error) {
+	var reqs []domain.EmailReq
+	var userIDs []int64
+
+	tags, err := q.agentTags(agentID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := q.pruneDispatchLogs(time.Now()); err != nil {
+		log.Printf("清理下发记录失败: %v", err)
+	}
+
+	// 使用读已提交，锁定限流规则后能看到其他agent刚提交的下发记录
+	err = q.DB.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+
+		if err := q.expireLeases(tx, now); err != nil {
+			return err
+		}
+
+		paused, err := pausedSenders(tx)
+		if err != nil {
+			return err
+		}
+		if paused.global {
+			return errQueueEmpty
+		}
+
+		query := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("(status = ? AND (available_at IS NULL OR available_at <= ?)) OR (status = ? AND lease_expires_at < ?)",
+				domain.OutboundStatusQueued, now, domain.OutboundStatusDispatched, now)
+		if len(tags) > 0 {
+			query = query.Where("pool IS NULL OR pool = '' OR pool IN ?", tags)
+		} else {
+			query = query.Where("pool IS NULL OR pool = ''")
+		}
+		if len(paused.senders) > 0 {
+			query = query.Where("from_addr IS NULL OR from_addr NOT IN ?", paused.senders)
+		}
+
+		estimate, limits, err := loadRateBudget(tx, now)
+		if err != nil {
+			return err
+		}
+		if accounts := estimate.exhausted(domain.RateLimitScopeAccount); len(accounts) > 0 {
+			query = query.Where("from_addr IS NULL OR from_addr NOT IN ?", accounts)
+		}
+		if users := estimate.exhausted(domain.RateLimitScopeUser); len(users) > 0 {
+			query = query.Where("user_id IS NULL OR user_id NOT IN ?", users)
+		}
+		if hosts := estimate.exhausted(domain.RateLimitScopeHost); len(hosts) > 0 {
+			query = query.Where("smtp_host IS NULL OR smtp_host NOT IN ?", hosts)
+		}
+
+		var msgs []domain.OutboundMessage
+		if err := query.
+			Order(q.priorityOrder(now)).
+			Limit(limit).
+			Find(&msgs).Error; err != nil {
+			return err
+		}
+
+		if len(msgs) == 0 {
+			return errQueueEmpty
+		}
+
+		budget, err := lockRateBudget(tx, limits, msgs, now)
+		if err != nil {
+			return err
+		}
+
+		var logs []domain.DispatchLog
+		for _, msg := range msgs {
+			// 本批次中超出剩余额度的邮件留在队列中
+			if !budget.take(msg) {
+				continue
+			}
+
+			var req domain.EmailReq
+			if err := json.Unmarshal([]byte(msg.Payload), &req); err != nil {
+				return fmt.Errorf("failed to unmarshal queued message %d: %w", msg.ID, err)
+			}
+
+			// 租约到期后重新下发，上一次下发不再计入限流
+			if msg.Status == domain.OutboundStatusDispatched {
+				if err := releaseDispatch(tx, msg.LeaseID); err != nil {
+					return err
+				}
+			}
+
+			lease := domain.EmailLease{
+				ID:        uuid.NewString(),
+				ExpiresAt: now.Add(q.LeaseTimeout),
+			}
+			req.Lease = &lease
+
+			if err := tx.Model(&msg).Updates(map[string]interface{}{
+				"status":           domain.OutboundStatusDispatched,
+				"lease_id":         lease.ID,
+				"agent_id":         agentID,
+				"lease_expires_at": lease.ExpiresAt,
+				"dispatch_count":   gorm.Expr("dispatch_count + 1"),
+				"dispatched_at":    now,
+			}).Error; err != nil {
+				return err
+			}
+
+			reqs = append(reqs, req)
+			userIDs = append(userIDs, msg.UserID)
+			logs = append(logs, domain.DispatchLog{
+				OutboundMessageID: msg.ID,
+				LeaseID:           lease.ID,
+				UserID:            msg.UserID,
+				FromAddr:          msg.FromAddr,
+				SmtpHost:          msg.SmtpHost,
+			})
+		}
+
+		if len(reqs) == 0 {
+			return errQueueEmpty
+		}
+		return tx.Create(&logs).Error
+	}, &sql.TxOptions{Isolation: sql.LevelReadCommitted})
+	if err != nil {
+		return nil, err
+	}
+
+	// 刷新令牌需要请求外部服务，放在事务之外
+	q.applyOAuthTokens(reqs, userIDs)
+	q.applyDKIMKeys(reqs, userIDs)
+	q.applySMIMEKeys(reqs, userIDs)
+	return reqs, nil
+}
+
+// agentTags 查询agent最近一次心跳上报的标签
+func (q *MailQueue) agentTags(agentID string) ([]string, error) {
+	if agentID == "" {
+		return nil, nil
+	}
+
+	var node domain.AgentNode
+	if err := q.DB.Select("tags").Where("id = ?", agentID).First(&node).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return node.Tags, nil
+}
+
+// DequeueWait 队列为空时最多等待wait，期间有新邮件入队立即返回，超时仍为空时返回errQueueEmpty
+func (q *MailQueue) DequeueWait(ctx context.Context, agentID string, limit int, wait time.Duration) ([]domain.EmailReq, error) {
+	deadline := time.NewTimer(wait)
+	defer deadline.Stop()
+
+	for {
+		// 先订阅再查询，避免错过查询与等待之间入队的邮件
+		notified := q.notifier.wait()
+
+		reqs, err := q.Dequeue(agentID, limit)
+		if !errors.Is(err, errQueueEmpty) {
+			return reqs, err
+		}
+
+		recheck := time.NewTimer(waitRecheckInterval)
+		select {
+		case <-ctx.Done():
+			recheck.Stop()
+			return nil, errQueueEmpty
+		case <-deadline.C:
+			recheck.Stop()
+			return nil, errQueueEmpty
+		case <-notified:
+		case <-recheck.C:
+		}
+		recheck.Stop()
+	}
+}
+
+// deferredRecipients 被临时拒绝(4xx)的收件人，地址为小写
+func deferredRecipients(req domain.EmailVerifyReq) map[string]bool {
+	deferred := make(map[string]bool)
+	for _, result := range req.Recipients {
+		if !result.Accepted && result.Code >= 400 && result.Code < 500 {
+			deferred[strings.ToLower(result.Addr)] = true
+		}
+	}
+	return deferred
+}
+
+// deferredError 被临时拒绝的收件人及其SMTP回复，作为邮件的最近一次错误
+func deferredError(req domain.EmailVerifyReq) string {
+	var parts []string
+	for _, result := range req.Recipients {
+		if !result.Accepted && result.Code >= 400 && result.Code < 500 {
+			parts = append(parts, fmt.Sprintf("%s: %d %s", result.Addr, result.Code, result.Reply))
+		}
+	}
+	return "部分收件人被临时拒绝: " + strings.Join(parts, "; ")
+}
+
+// retainRecipients 只保留邮件中指定的收件人(含抄送、密送)，返回新的邮件内容
+func retainRecipients(payload string, keep map[string]bool) (string, error) {
+	var req domain.EmailReq
+	if err := json.Unmarshal([]byte(payload), &req); err != nil {
+		return "", fmt.Errorf("failed to unmarshal queued message: %w", err)
+	}
+
+	filter := func(list []domain.EmailAddress) []domain.EmailAddress {
+		var kept []domain.EmailAddress
+		for _, rcpt := range list {
+			if keep[strings.ToLower(rcpt.Addr)] {
+				kept = append(kept, rcpt)
+			}
+		}
+		return kept
+	}
+	req.To, req.CC, req.BCC = filter(req.To), filter(req.CC), filter(req.BCC)
+
+	data, err := json.Marshal(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal email request: %w", err)
+	}
+	return string(data), nil
+}
+
+// priorityOrder 按等待时间提升后的优先级降序排列，同级按入队顺序。
+// 等待时间从邮件可被获取时算起，定时或退避中的邮件不会因此前的等待获得更高优先级
+func (q *MailQueue) priorityOrder(now time.Time) clause.OrderBy {
+	return clause.OrderBy{Expression: clause.Expr{
+		SQL:  "priority + FLOOR(TIMESTAMPDIFF(SECOND, COALESCE(available_at, created_at), ?) / ?) DESC, id",
+		Vars: []interface{}{now, int64(q.PriorityAging / time.Second)},
+	}}
+}
+
+// Ack 根据租约记录agent返回的发送结果，状态变化见ackTransition。
+// 租约过期但邮件尚未被重新获取时仍接受确认，避免重复发送；邮件已被再次租出时返回errLeaseNotFound
+func (q *MailQueue) Ack(req domain.EmailVerifyReq) (AckResult, error) {
+	var result AckResult
+
+	err := q.DB.Transaction(func(tx *gorm.DB) error {
+		var msg domain.OutboundMessage
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("email_req_id = ? AND lease_id = ? AND status = ?",
+				req.ID, req.LeaseID, domain.OutboundStatusDispatched).
+			First(&msg).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return errLeaseNotFound
+			}
+			return err
+		}
+
+		t, err := q.ackTransition(msg, req, time.Now())
+		if err != nil {
+			return err
+		}
+		result = t.result
+
+		if err := tx.Model(&msg).Updates(t.updates).Error; err != nil {
+			return err
+		}
+		if !result.Final {
+			if err := releaseDispatch(tx, req.LeaseID); err != nil {
+				return err
+			}
+		}
+
+		// 失败的邮件连同最后一次错误转入死信，供管理员处理
+		if t.deadLetterReason != "" {
+			msg.Payload = t.payload
+			return q.deadLetter(tx, msg, t.deadLetterReason, t.lastError, result.Attempt)
+		}
+		return nil
+	})
+	if err != nil {
+		return AckResult{}, err
+	}
+
+	return result, nil
+}
+
+// ackTransition 确认结果对应的队列状态变化
+type ackTransition struct {
+	result           AckResult
+	updates          map[string]interface{} // 邮件需要更新的字段
+	deadLetterReason string                 // 转入死信的原因，为空时不转入
+	lastError        string                 // 转入死信时记录的错误
+	payload          string                 // 转入死信的邮件内容
+}
+
+// ackTransition 根据agent返回的发送结果计算邮件的下一个状态：临时失败且未超过最大尝试次数时按指数退避重新入队，否则转入死信；
+// 部分收件人被临时拒绝(如451灰名单)而其余收件人已投递或被永久拒绝时只向这些收件人重新投递，
+// 重试次数用完时只含这些收件人的邮件转入死信，重新发送时已投递的收件人不会收到重复邮件
+func (q *MailQueue) ackTransition(msg domain.OutboundMessage, req domain.EmailVerifyReq, now time.Time) (ackTransition, error) {
+	t := ackTransition{
+		result: AckResult{
+			Attempt: msg.Attempts + 1,
+			AgentID: msg.AgentID,
+			Final:   true,
+		},
+		lastError: req.Error,
+		payload:   msg.Payload,
+	}
+	t.updates = map[string]interface{}{
+		"attempts":         t.result.Attempt,
+		"lease_expires_at": nil,
+		"last_error":       truncateString(req.Error, maxLastErrorLength),
+	}
+
+	retry := func() {
+		next := now.Add(q.Retry.Backoff(t.result.Attempt))
+		t.result.Final = false
+		t.result.NextAttempt = &next
+		t.updates["status"] = domain.OutboundStatusQueued
+		t.updates["available_at"] = next
+	}
+	fail := func(reason string) {
+		t.updates["status"] = domain.OutboundStatusFailed
+		t.updates["completed_at"] = now
+		t.deadLetterReason = reason
+	}
+
+	canRetry := t.result.Attempt < q.Retry.MaxAttempts
+	deferred := deferredRecipients(req)
+	switch {
+	case !req.Temporary && len(deferred) > 0:
+		payload, err := retainRecipients(msg.Payload, deferred)
+		if err != nil {
+			return ackTransition{}, err
+		}
+		t.payload = payload
+		t.lastError = deferredError(req)
+		t.updates["last_error"] = truncateString(t.lastError, maxLastErrorLength)
+		if canRetry {
+			retry()
+			t.updates["payload"] = payload
+		} else {
+			fail(domain.DeadLetterReasonRetriesExhausted)
+		}
+	case req.Success:
+		t.updates["status"] = domain.OutboundStatusSent
+		t.updates["completed_at"] = now
+	case req.Temporary && canRetry:
+		retry()
+	case req.Temporary:
+		fail(domain.DeadLetterReasonRetriesExhausted)
+	default:
+		fail(domain.DeadLetterReasonPermanentFailure)
+	}
+	return t, nil
+}
+
+// smtpHost 邮件的目标SMTP服务器，用于按服务器统计队列
+func smtpHost(req domain.EmailReq) string {
+	return truncateString(strings.ToLower(req.Server.Host), 255)
+}
+
+// truncateString 截断字符串为最多n个字符，不会截断多字节字符，避免超出字段长度
+func truncateString(s string, n int) string {
+	for i := range s {
+		if n == 0 {
+			return s[:i]
+		}
+		n--
+	}
+	return s
+}
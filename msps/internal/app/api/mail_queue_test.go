@@ -0,0 +1,263 @@
+package api
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+	"msps/internal/app/model/domain"
+)
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	p := RetryPolicy{MaxAttempts: 5, BaseDelay: 30 * time.Second, MaxDelay: 5 * time.Minute}
+	tests := []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{1, 30 * time.Second},
+		{2, time.Minute},
+		{3, 2 * time.Minute},
+		{4, 4 * time.Minute},
+		{5, 5 * time.Minute},
+		{50, 5 * time.Minute},
+	}
+	for _, tt := range tests {
+		if got := p.Backoff(tt.attempt); got != tt.want {
+			t.Errorf("Backoff(%d) = %v, want %v", tt.attempt, got, tt.want)
+		}
+	}
+}
+
+func TestDeferredRecipients(t *testing.T) {
+	req := domain.EmailVerifyReq{Recipients: []domain.RecipientResult{
+		{Addr: "ok@example.com", Accepted: true, Code: 250},
+		{Addr: "Grey@Example.com", Code: 451, Reply: "4.7.1 greylisted"},
+		{Addr: "gone@example.com", Code: 550, Reply: "5.1.1 user unknown"},
+		{Addr: "unknown@example.com"},
+	}}
+
+	got := deferredRecipients(req)
+	if len(got) != 1 || !got["grey@example.com"] {
+		t.Fatalf("deferredRecipients = %v, want only grey@example.com", got)
+	}
+	if msg := deferredError(req); !strings.Contains(msg, "Grey@Example.com: 451 4.7.1 greylisted") || strings.Contains(msg, "gone@") {
+		t.Errorf("deferredError = %q", msg)
+	}
+}
+
+func TestRetainRecipients(t *testing.T) {
+	payload := queuedPayload(t)
+	got, err := retainRecipients(payload, map[string]bool{"b@example.com": true, "d@example.com": true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var req domain.EmailReq
+	if err := json.Unmarshal([]byte(got), &req); err != nil {
+		t.Fatal(err)
+	}
+	if addrs(req.To) != "B@example.com" || addrs(req.CC) != "" || addrs(req.BCC) != "d@example.com" {
+		t.Errorf("to=%q cc=%q bcc=%q", addrs(req.To), addrs(req.CC), addrs(req.BCC))
+	}
+	if req.Subject != "hello" || req.From.Addr != "sender@example.com" {
+		t.Errorf("other fields changed: %+v", req)
+	}
+
+	if _, err := retainRecipients("{", nil); err == nil {
+		t.Error("expected error for malformed payload")
+	}
+}
+
+func TestAckTransition(t *testing.T) {
+	q := NewMailQueue(nil, 0, 0, 0, 0, 0, RetryPolicy{MaxAttempts: 3, BaseDelay: time.Minute, MaxDelay: time.Hour})
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	partial := []domain.RecipientResult{
+		{Addr: "a@example.com", Accepted: true, Code: 250},
+		{Addr: "b@example.com", Code: 451, Reply: "try later"},
+		{Addr: "c@example.com", Code: 550, Reply: "no such user"},
+	}
+
+	tests := []struct {
+		name       string
+		attempts   int // 本次确认前已尝试的次数
+		req        domain.EmailVerifyReq
+		status     string
+		final      bool
+		deadLetter string
+		retained   string // 重新入队或转入死信的邮件中保留的收件人
+	}{
+		{
+			name:   "success",
+			req:    domain.EmailVerifyReq{Success: true},
+			status: domain.OutboundStatusSent,
+			final:  true,
+		},
+		{
+			name:   "temporary failure is retried",
+			req:    domain.EmailVerifyReq{Temporary: true, Error: "421 busy"},
+			status: domain.OutboundStatusQueued,
+		},
+		{
+			name:       "temporary failure on the last attempt",
+			attempts:   2,
+			req:        domain.EmailVerifyReq{Temporary: true, Error: "421 busy"},
+			status:     domain.OutboundStatusFailed,
+			final:      true,
+			deadLetter: domain.DeadLetterReasonRetriesExhausted,
+		},
+		{
+			name:       "permanent failure",
+			req:        domain.EmailVerifyReq{Error: "550 rejected"},
+			status:     domain.OutboundStatusFailed,
+			final:      true,
+			deadLetter: domain.DeadLetterReasonPermanentFailure,
+		},
+		{
+			name:     "deferred recipients are retried alone",
+			req:      domain.EmailVerifyReq{Success: true, Recipients: partial},
+			status:   domain.OutboundStatusQueued,
+			retained: "b@example.com",
+		},
+		{
+			name:       "deferred recipients on the last attempt",
+			attempts:   2,
+			req:        domain.EmailVerifyReq{Success: true, Recipients: partial},
+			status:     domain.OutboundStatusFailed,
+			final:      true,
+			deadLetter: domain.DeadLetterReasonRetriesExhausted,
+			retained:   "b@example.com",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := domain.OutboundMessage{Attempts: tt.attempts, AgentID: "agent-1", Payload: ackPayload(t)}
+			got, err := q.ackTransition(msg, tt.req, now)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got.result.Attempt != tt.attempts+1 || got.result.AgentID != "agent-1" {
+				t.Errorf("result = %+v", got.result)
+			}
+			if got.updates["status"] != tt.status {
+				t.Errorf("status = %v, want %s", got.updates["status"], tt.status)
+			}
+			if got.result.Final != tt.final {
+				t.Errorf("final = %v, want %v", got.result.Final, tt.final)
+			}
+			if got.deadLetterReason != tt.deadLetter {
+				t.Errorf("dead letter reason = %q, want %q", got.deadLetterReason, tt.deadLetter)
+			}
+
+			if tt.final {
+				if got.result.NextAttempt != nil || got.updates["completed_at"] != now {
+					t.Errorf("final ack should complete the message: %+v", got.updates)
+				}
+			} else {
+				next := now.Add(q.Retry.Backoff(tt.attempts + 1))
+				if got.result.NextAttempt == nil || !got.result.NextAttempt.Equal(next) || got.updates["available_at"] != next {
+					t.Errorf("retry should be available at %v: %+v", next, got.updates)
+				}
+			}
+
+			if tt.retained != "" {
+				payload := got.payload
+				if !tt.final {
+					payload, _ = got.updates["payload"].(string)
+				}
+				var req domain.EmailReq
+				if err := json.Unmarshal([]byte(payload), &req); err != nil {
+					t.Fatal(err)
+				}
+				if addrs(req.To) != tt.retained {
+					t.Errorf("retained recipients = %q, want %q", addrs(req.To), tt.retained)
+				}
+				if !strings.HasPrefix(got.lastError, "部分收件人被临时拒绝") {
+					t.Errorf("last error = %q", got.lastError)
+				}
+			} else if _, ok := got.updates["payload"]; ok {
+				t.Error("payload should not change")
+			}
+		})
+	}
+}
+
+func TestPriorityOrderAging(t *testing.T) {
+	db, err := gorm.Open(mysql.New(mysql.Config{DSN: "user:pass@tcp(127.0.0.1:3306)/test", SkipInitializeWithVersion: true}),
+		&gorm.Config{DryRun: true, DisableAutomaticPing: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	q := NewMailQueue(db, 0, 0, 5*time.Minute, 0, 0, RetryPolicy{})
+	now := time.Now()
+	stmt := db.Order(q.priorityOrder(now)).Find(&[]domain.OutboundMessage{}).Statement
+
+	sql := stmt.SQL.String()
+	if !strings.Contains(sql, "ORDER BY priority + FLOOR(TIMESTAMPDIFF(SECOND, COALESCE(available_at, created_at), ?) / ?) DESC, id") {
+		t.Errorf("unexpected order: %s", sql)
+	}
+	if len(stmt.Vars) != 2 || stmt.Vars[0] != now || stmt.Vars[1] != int64(300) {
+		t.Errorf("vars = %v", stmt.Vars)
+	}
+}
+
+func TestTruncateString(t *testing.T) {
+	tests := []struct {
+		s    string
+		n    int
+		want string
+	}{
+		{"hello", 10, "hello"},
+		{"hello", 5, "hello"},
+		{"hello", 3, "hel"},
+		{"收件人被拒绝", 3, "收件人"},
+		{"", 3, ""},
+		{"abc", 0, ""},
+	}
+	for _, tt := range tests {
+		if got := truncateString(tt.s, tt.n); got != tt.want {
+			t.Errorf("truncateString(%q, %d) = %q, want %q", tt.s, tt.n, got, tt.want)
+		}
+	}
+}
+
+// queuedPayload 收件人、抄送、密送各有多个地址的邮件
+func queuedPayload(t *testing.T) string {
+	return marshalReq(t, domain.EmailReq{
+		From:    &domain.EmailAddress{Addr: "sender@example.com"},
+		To:      []domain.EmailAddress{{Addr: "a@example.com"}, {Addr: "B@example.com"}},
+		CC:      []domain.EmailAddress{{Addr: "c@example.com"}},
+		BCC:     []domain.EmailAddress{{Addr: "d@example.com"}},
+		Subject: "hello",
+	})
+}
+
+// ackPayload 与TestAckTransition中收件人结果对应的邮件
+func ackPayload(t *testing.T) string {
+	return marshalReq(t, domain.EmailReq{
+		From: &domain.EmailAddress{Addr: "sender@example.com"},
+		To:   []domain.EmailAddress{{Addr: "a@example.com"}, {Addr: "b@example.com"}, {Addr: "c@example.com"}},
+	})
+}
+
+func marshalReq(t *testing.T, req domain.EmailReq) string {
+	t.Helper()
+	data, err := json.Marshal(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func addrs(list []domain.EmailAddress) string {
+	var result []string
+	for _, a := range list {
+		result = append(result, a.Addr)
+	}
+	return strings.Join(result, ",")
+}
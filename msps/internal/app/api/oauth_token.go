@@ -0,0 +1,389 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"msps/internal/app/model/domain"
+)
+
+const (
+	// oauthRefreshTimeout 请求令牌端点的超时时间
+	oauthRefreshTimeout = 10 * time.Second
+	// oauthMinTokenLifetime 访问令牌剩余有效期低于该值时提前刷新
+	oauthMinTokenLifetime = time.Minute
+	// oauthRefreshInterval 后台检查即将过期的访问令牌的间隔
+	oauthRefreshInterval = 30 * time.Second
+)
+
+// oauthTrustedHosts 配置中受信任的令牌端点主机(host或host:port)，可使用http和内网地址，
+// 用于部署在内网的授权服务或本地调试用的令牌端点
+type oauthTrustedHosts map[string]bool
+
+func newOAuthTrustedHosts(hosts []string) oauthTrustedHosts {
+	trusted := make(oauthTrustedHosts, len(hosts))
+	for _, host := range hosts {
+		if host = strings.ToLower(strings.TrimSpace(host)); host != "" {
+			trusted[host] = true
+		}
+	}
+	return trusted
+}
+
+// contains hostport为host:port形式，配置中的主机不带端口时匹配所有端口
+func (t oauthTrustedHosts) contains(hostport string) bool {
+	hostport = strings.ToLower(hostport)
+	if t[hostport] {
+		return true
+	}
+	host, _, err := net.SplitHostPort(hostport)
+	return err == nil && t[host]
+}
+
+// newOAuthHTTPClient 请求令牌端点的客户端。令牌端点由用户配置，除受信任的主机外只允许连接公网地址，
+// 且不跟随重定向，避免借此访问内网服务(域名在校验后重新解析到内网地址时同样拒绝)
+func newOAuthHTTPClient(trusted oauthTrustedHosts) *http.Client {
+	direct := &net.Dialer{Timeout: oauthRefreshTimeout}
+	guarded := &net.Dialer{
+		Timeout: oauthRefreshTimeout,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !isPublicIP(ip) {
+				return fmt.Errorf("token endpoint address %s is not allowed", host)
+			}
+			return nil
+		},
+	}
+
+	return &http.Client{
+		Timeout: oauthRefreshTimeout,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				if trusted.contains(addr) {
+					return direct.DialContext(ctx, network, addr)
+				}
+				return guarded.DialContext(ctx, network, addr)
+			},
+			TLSHandshakeTimeout: oauthRefreshTimeout,
+		},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// cgnatNet 运营商级NAT共享地址(RFC 6598)，同样不属于公网
+var cgnatNet = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// validateTokenURL 校验令牌端点：必须为https，且主机不能是回环、内网、链路本地等非公网地址；
+// 受信任的主机不受限制，也可以使用http
+func (t oauthTrustedHosts) validateTokenURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return errors.New("地址格式错误")
+	}
+	host := u.Hostname()
+	if host == "" || u.User != nil {
+		return errors.New("地址格式错误")
+	}
+
+	port := u.Port()
+	if port == "" {
+		port = map[string]string{"http": "80", "https": "443"}[u.Scheme]
+	}
+	if t.contains(net.JoinHostPort(host, port)) {
+		if u.Scheme != "https" && u.Scheme != "http" {
+			return errors.New("必须使用http或https")
+		}
+		return nil
+	}
+
+	if u.Scheme != "https" {
+		return errors.New("必须使用https")
+	}
+	ips := []net.IP{net.ParseIP(host)}
+	if ips[0] == nil {
+		if ips, err = net.LookupIP(host); err != nil || len(ips) == 0 {
+			return fmt.Errorf("无法解析域名 %s", host)
+		}
+	}
+	for _, ip := range ips {
+		if !isPublicIP(ip) {
+			return errors.New("不允许使用内网地址")
+		}
+	}
+	return nil
+}
+
+// isPublicIP 是否为可访问的公网单播地址
+func isPublicIP(ip net.IP) bool {
+	return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() || cgnatNet.Contains(ip))
+}
+
+// oauthTokenResp 令牌端点的响应(RFC 6749 5.1、5.2)
+type oauthTokenResp struct {
+	AccessToken      string `json:"access_token"`
+	ExpiresIn        int64  `json:"expires_in"`
+	RefreshToken     string `json:"refresh_token"` // 部分服务商会轮换刷新令牌
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// SetOAuthTrustedHosts 设置受信任的令牌端点主机
+func (q *MailQueue) SetOAuthTrustedHosts(hosts []string) {
+	q.oauthTrusted = newOAuthTrustedHosts(hosts)
+	q.oauthClient = newOAuthHTTPClient(q.oauthTrusted)
+}
+
+// validateOAuthTokenURL 校验用户配置的令牌端点
+func (q *MailQueue) validateOAuthTokenURL(raw string) error {
+	return q.oauthTrusted.validateTokenURL(raw)
+}
+
+// oauthMinLifetime 下发的访问令牌须在租约到期后仍然有效
+func (q *MailQueue) oauthMinLifetime() time.Duration {
+	return max(q.LeaseTimeout, oauthMinTokenLifetime)
+}
+
+// applyOAuthTokens 发件账户配置了OAuth2时，为下发的邮件填入短期访问令牌并改用XOAUTH2认证，userIDs与reqs一一对应。
+// 只使用入队用户自己的发件账户；访问令牌只随本次下发返回，不写入队列中的邮件内容；获取失败时保留原有认证信息，由agent按发送失败重试。
+// 令牌通常已由RunOAuthRefresher提前刷新，需要刷新时不同账户并发请求，避免一个响应慢的令牌端点拖慢整批下发。
+func (q *MailQueue) applyOAuthTokens(reqs []domain.EmailReq, userIDs []int64) {
+	type tokenKey struct {
+		userID int64
+		addr   string
+	}
+	tokens := make(map[tokenKey]string)
+	for i := range reqs {
+		if reqs[i].From != nil {
+			tokens[tokenKey{userID: userIDs[i], addr: reqs[i].From.Addr}] = ""
+		}
+	}
+
+	var (
+		mu sync.Mutex
+		wg sync.WaitGroup
+	)
+	for key := range tokens {
+		wg.Add(1)
+		go func(key tokenKey) {
+			defer wg.Done()
+			token, err := q.oauthAccessToken(key.userID, key.addr)
+			if err != nil {
+				log.Printf("获取 %s 的OAuth2访问令牌失败: %v", key.addr, err)
+				return
+			}
+			mu.Lock()
+			tokens[key] = token
+			mu.Unlock()
+		}(key)
+	}
+	wg.Wait()
+
+	for i := range reqs {
+		if reqs[i].From == nil {
+			continue
+		}
+		token := tokens[tokenKey{userID: userIDs[i], addr: reqs[i].From.Addr}]
+		if token == "" {
+			continue
+		}
+
+		reqs[i].Auth = &domain.SMTPAuth{
+			User:      reqs[i].From.Addr,
+			Pass:      token,
+			Mechanism: domain.SMTPAuthXOAUTH2,
+		}
+	}
+}
+
+// oauthAccessToken 返回用户userID的发件账户可用的访问令牌，账户不属于该用户或未配置OAuth2时返回空字符串
+func (q *MailQueue) oauthAccessToken(userID int64, addr string) (string, error) {
+	var account domain.UserMailAccount
+	if err := q.DB.Where("user_id = ? AND email = ?", userID, addr).First(&account).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return "", nil
+		}
+		return "", err
+	}
+	if !account.UsesOAuth() {
+		return "", nil
+	}
+	if token, ok := cachedOAuthToken(account, q.oauthMinLifetime()); ok {
+		return token, nil
+	}
+	return q.refreshAccountToken(account.ID, q.oauthMinLifetime())
+}
+
+// cachedOAuthToken 保存的访问令牌在minLifetime后仍然有效时直接使用
+func cachedOAuthToken(account domain.UserMailAccount, minLifetime time.Duration) (string, bool) {
+	if account.OAuthAccessToken != "" && account.OAuthExpiresAt != nil && account.OAuthExpiresAt.After(time.Now().Add(minLifetime)) {
+		return account.OAuthAccessToken, true
+	}
+	return "", false
+}
+
+// refreshAccountToken 刷新发件账户的访问令牌并保存。同一账户的刷新串行执行，
+// 等待期间其他请求已刷新时直接使用新令牌，不会重复请求令牌端点
+func (q *MailQueue) refreshAccountToken(accountID int64, minLifetime time.Duration) (string, error) {
+	unlock := q.oauthLocks.lock(accountID)
+	defer unlock()
+
+	var account domain.UserMailAccount
+	if err := q.DB.First(&account, accountID).Error; err != nil {
+		return "", err
+	}
+	if !account.UsesOAuth() {
+		return "", nil
+	}
+	if token, ok := cachedOAuthToken(account, minLifetime); ok {
+		return token, nil
+	}
+
+	now := time.Now()
+	token, err := q.refreshOAuthToken(account)
+	if err != nil {
+		return "", err
+	}
+
+	updates := map[string]interface{}{
+		"oauth_access_token": token.AccessToken,
+		"oauth_expires_at":   nil,
+	}
+	if token.ExpiresIn > 0 {
+		updates["oauth_expires_at"] = now.Add(time.Duration(token.ExpiresIn) * time.Second)
+	}
+	if token.RefreshToken != "" {
+		updates["oauth_refresh_token"] = token.RefreshToken
+	}
+	if err := q.DB.Model(&account).Updates(updates).Error; err != nil {
+		// 令牌仍可用于本次下发，下次会重新刷新
+		log.Printf("保存 %s 的OAuth2访问令牌失败: %v", account.Email, err)
+	}
+	return token.AccessToken, nil
+}
+
+// RunOAuthRefresher 定期为有待发送邮件的OAuth2发件账户提前刷新即将过期的访问令牌，下发时无需等待令牌端点
+func (q *MailQueue) RunOAuthRefresher() {
+	ticker := time.NewTicker(oauthRefreshInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		q.refreshExpiringOAuthTokens()
+	}
+}
+
+func (q *MailQueue) refreshExpiringOAuthTokens() {
+	// 提前两个检查周期刷新，保证下发时令牌仍满足租约期内有效的要求
+	minLifetime := q.oauthMinLifetime() + 2*oauthRefreshInterval
+	deadline := time.Now().Add(minLifetime)
+
+	var accounts []domain.UserMailAccount
+	err := q.DB.Select("id", "email").
+		Where("oauth_token_url <> '' AND oauth_refresh_token <> ''").
+		// 未返回有效期的令牌无法提前刷新，由下发时处理
+		Where("oauth_access_token IS NULL OR oauth_access_token = '' OR oauth_expires_at < ?", deadline).
+		Where("EXISTS (SELECT 1 FROM outbound_messages m WHERE m.user_id = user_mail_accounts.user_id AND m.from_addr = user_mail_accounts.email AND m.status = ? AND (m.available_at IS NULL OR m.available_at < ?))",
+			domain.OutboundStatusQueued, deadline).
+		Find(&accounts).Error
+	if err != nil {
+		log.Printf("查询需要刷新OAuth2访问令牌的账户失败: %v", err)
+		return
+	}
+
+	for _, account := range accounts {
+		if _, err := q.refreshAccountToken(account.ID, minLifetime); err != nil {
+			log.Printf("刷新 %s 的OAuth2访问令牌失败: %v", account.Email, err)
+		}
+	}
+}
+
+// keyedMutex 按键加锁，不同键之间互不阻塞
+type keyedMutex struct {
+	mu    sync.Mutex
+	locks map[int64]*keyedLock
+}
+
+type keyedLock struct {
+	sync.Mutex
+	refs int // 持有或等待该锁的数量，为0时删除
+}
+
+// lock 锁定key，返回解锁函数
+func (k *keyedMutex) lock(key int64) func() {
+	k.mu.Lock()
+	if k.locks == nil {
+		k.locks = make(map[int64]*keyedLock)
+	}
+	l, ok := k.locks[key]
+	if !ok {
+		l = &keyedLock{}
+		k.locks[key] = l
+	}
+	l.refs++
+	k.mu.Unlock()
+
+	l.Lock()
+	return func() {
+		l.Unlock()
+		k.mu.Lock()
+		if l.refs--; l.refs == 0 {
+			delete(k.locks, key)
+		}
+		k.mu.Unlock()
+	}
+}
+
+// refreshOAuthToken 使用刷新令牌向令牌端点换取新的访问令牌
+func (q *MailQueue) refreshOAuthToken(account domain.UserMailAccount) (oauthTokenResp, error) {
+	// 兼容校验规则加入前保存的令牌端点
+	if err := q.validateOAuthTokenURL(account.OAuthTokenURL); err != nil {
+		return oauthTokenResp{}, fmt.Errorf("invalid token endpoint: %w", err)
+	}
+
+	form := url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {account.OAuthRefreshToken},
+		"client_id":     {account.OAuthClientID},
+	}
+	if account.OAuthClientSecret != "" {
+		form.Set("client_secret", account.OAuthClientSecret)
+	}
+	if account.OAuthScope != "" {
+		form.Set("scope", account.OAuthScope)
+	}
+
+	resp, err := q.oauthClient.Post(account.OAuthTokenURL, "application/x-www-form-urlencoded", strings.NewReader(form.Encode()))
+	if err != nil {
+		return oauthTokenResp{}, err
+	}
+	defer resp.Body.Close()
+
+	var token oauthTokenResp
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&token); err != nil {
+		return oauthTokenResp{}, fmt.Errorf("unexpected token response (HTTP %d): %w", resp.StatusCode, err)
+	}
+	if token.Error != "" {
+		return oauthTokenResp{}, fmt.Errorf("token endpoint returned %s: %s", token.Error, token.ErrorDescription)
+	}
+	if resp.StatusCode != http.StatusOK || token.AccessToken == "" {
+		return oauthTokenResp{}, fmt.Errorf("token endpoint returned HTTP %d without access token", resp.StatusCode)
+	}
+	return token, nil
+}
@@ -0,0 +1,146 @@
+package api
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"msps/internal/app/model/domain"
+)
+
+// maxQueueBreakdowns 按发件账户、SMTP服务器统计时最多返回的条数
+const maxQueueBreakdowns = 50
+
+// pauseState 当前暂停下发的情况
+type pauseState struct {
+	global  bool
+	senders []string
+}
+
+// pausedSenders 查询暂停下发的发件账户
+func pausedSenders(tx *gorm.DB) (pauseState, error) {
+	var state pauseState
+
+	var addrs []string
+	if err := tx.Model(&domain.QueuePause{}).Pluck("from_addr", &addrs).Error; err != nil {
+		return state, err
+	}
+	for _, addr := range addrs {
+		if addr == domain.QueuePauseGlobal {
+			state.global = true
+			continue
+		}
+		state.senders = append(state.senders, addr)
+	}
+	return state, nil
+}
+
+// Pause 暂停下发，fromAddr为空时暂停全部邮件。已下发的邮件不受影响
+func (q *MailQueue) Pause(fromAddr, reason, pausedBy string) error {
+	if fromAddr == "" {
+		fromAddr = domain.QueuePauseGlobal
+	}
+
+	return q.DB.Clauses(clause.OnConflict{
+		DoUpdates: clause.AssignmentColumns([]string{"reason", "paused_by"}),
+	}).Create(&domain.QueuePause{
+		FromAddr: fromAddr,
+		Reason:   truncateString(reason, 255),
+		PausedBy: pausedBy,
+	}).Error
+}
+
+// Resume 恢复下发，fromAddr为空时恢复全局暂停。发件账户的单独暂停需分别恢复
+func (q *MailQueue) Resume(fromAddr string) error {
+	if fromAddr == "" {
+		fromAddr = domain.QueuePauseGlobal
+	}
+
+	if err := q.DB.Where("from_addr = ?", fromAddr).Delete(&domain.QueuePause{}).Error; err != nil {
+		return err
+	}
+
+	q.notifier.broadcast()
+	return nil
+}
+
+// Stats 统计队列深度、等待时间、租约、吞吐量及按发件账户和SMTP服务器的分布
+func (q *MailQueue) Stats() (domain.QueueStats, error) {
+	var stats domain.QueueStats
+	now := time.Now()
+	outbound := func() *gorm.DB { return q.DB.Model(&domain.OutboundMessage{}) }
+
+	counts := []struct {
+		dst   *int64
+		query string
+		args  []interface{}
+	}{
+		{&stats.Ready, "status = ? AND (available_at IS NULL OR available_at <= ?)", []interface{}{domain.OutboundStatusQueued, now}},
+		{&stats.Delayed, "status = ? AND available_at > ?", []interface{}{domain.OutboundStatusQueued, now}},
+		{&stats.InFlight, "status = ? AND lease_expires_at >= ?", []interface{}{domain.OutboundStatusDispatched, now}},
+		{&stats.ExpiredLeases, "status = ? AND lease_expires_at < ?", []interface{}{domain.OutboundStatusDispatched, now}},
+		{&stats.Throughput.SentLastMinute, "status = ? AND completed_at >= ?", []interface{}{domain.OutboundStatusSent, now.Add(-time.Minute)}},
+		{&stats.Throughput.SentLastHour, "status = ? AND completed_at >= ?", []interface{}{domain.OutboundStatusSent, now.Add(-time.Hour)}},
+		{&stats.Throughput.FailedLastHour, "status = ? AND completed_at >= ?", []interface{}{domain.OutboundStatusFailed, now.Add(-time.Hour)}},
+		{&stats.Throughput.SentLastDay, "status = ? AND completed_at >= ?", []interface{}{domain.OutboundStatusSent, now.Add(-24 * time.Hour)}},
+		{&stats.Throughput.FailedLastDay, "status = ? AND completed_at >= ?", []interface{}{domain.OutboundStatusFailed, now.Add(-24 * time.Hour)}},
+		{&stats.Throughput.CancelledLastDay, "status = ? AND completed_at >= ?", []interface{}{domain.OutboundStatusCancelled, now.Add(-24 * time.Hour)}},
+	}
+	for _, c := range counts {
+		if err := outbound().Where(c.query, c.args...).Count(c.dst).Error; err != nil {
+			return stats, err
+		}
+	}
+
+	// 最早的可下发邮件，包括租约已过期等待重新下发的邮件
+	var oldest domain.OutboundMessage
+	result := outbound().Select("created_at").
+		Where("(status = ? AND (available_at IS NULL OR available_at <= ?)) OR (status = ? AND lease_expires_at < ?)",
+			domain.OutboundStatusQueued, now, domain.OutboundStatusDispatched, now).
+		Order("created_at").
+		Limit(1).
+		Find(&oldest)
+	if result.Error != nil {
+		return stats, result.Error
+	}
+	if result.RowsAffected > 0 {
+		stats.OldestReadyAt = &oldest.CreatedAt
+		stats.OldestAge = int64(now.Sub(oldest.CreatedAt) / time.Second)
+	}
+
+	var err error
+	if stats.BySender, err = q.breakdown("from_addr"); err != nil {
+		return stats, err
+	}
+	if stats.ByHost, err = q.breakdown("smtp_host"); err != nil {
+		return stats, err
+	}
+
+	if err := q.DB.Order("created_at").Find(&stats.Pauses).Error; err != nil {
+		return stats, err
+	}
+	for _, pause := range stats.Pauses {
+		if pause.FromAddr == domain.QueuePauseGlobal {
+			stats.Paused = true
+		}
+	}
+
+	return stats, nil
+}
+
+// breakdown 按指定字段统计未完成邮件，数量多的在前
+func (q *MailQueue) breakdown(column string) ([]domain.QueueBreakdown, error) {
+	var rows []domain.QueueBreakdown
+	err := q.DB.Model(&domain.OutboundMessage{}).
+		Select("COALESCE("+column+", '') AS `key`, "+
+			"SUM(CASE WHEN status = ? THEN 1 ELSE 0 END) AS queued, "+
+			"SUM(CASE WHEN status = ? THEN 1 ELSE 0 END) AS in_flight, "+
+			"MIN(created_at) AS oldest_queued_at",
+			domain.OutboundStatusQueued, domain.OutboundStatusDispatched).
+		Where("status IN ?", []string{domain.OutboundStatusQueued, domain.OutboundStatusDispatched}).
+		Group(column).
+		Order("COUNT(*) DESC").
+		Limit(maxQueueBreakdowns).
+		Scan(&rows).Error
+	return rows, err
+}
@@ -0,0 +1,274 @@
+package api
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"msps/internal/app/model/domain"
+)
+
+const (
+	// dispatchLogRetention 下发记录保留时间，需覆盖最长的限流窗口
+	dispatchLogRetention = 24 * time.Hour
+	// dispatchLogPruneInterval 清理过期下发记录的间隔
+	dispatchLogPruneInterval = time.Minute
+)
+
+// ErrRateLimitNotFound 限流规则不存在
+var ErrRateLimitNotFound = errors.New("rate limit not found")
+
+// rateLimitState 一条限流规则在本次下发中剩余的额度
+type rateLimitState struct {
+	key       string
+	remaining int
+}
+
+// rateBudget 本次下发可用的额度，按范围和Key索引
+type rateBudget map[string]*rateLimitState
+
+func rateBudgetKey(scope, key string) string {
+	return scope + ":" + strings.ToLower(key)
+}
+
+// take 邮件所属的账户、用户及SMTP服务器均有剩余额度时扣减额度并返回true
+func (b rateBudget) take(msg domain.OutboundMessage) bool {
+	var states []*rateLimitState
+	for _, key := range []string{
+		rateBudgetKey(domain.RateLimitScopeAccount, msg.FromAddr),
+		rateBudgetKey(domain.RateLimitScopeUser, strconv.FormatInt(msg.UserID, 10)),
+		rateBudgetKey(domain.RateLimitScopeHost, msg.SmtpHost),
+	} {
+		state, ok := b[key]
+		if !ok {
+			continue
+		}
+		if state.remaining <= 0 {
+			return false
+		}
+		states = append(states, state)
+	}
+
+	for _, state := range states {
+		state.remaining--
+	}
+	return true
+}
+
+// exhausted 返回指定范围内已无额度的Key
+func (b rateBudget) exhausted(scope string) []string {
+	var keys []string
+	prefix := scope + ":"
+	for k, state := range b {
+		if strings.HasPrefix(k, prefix) && state.remaining <= 0 {
+			keys = append(keys, state.key)
+		}
+	}
+	return keys
+}
+
+// loadRateBudget 按当前用量计算限流规则的剩余额度，不加锁，用于在获取邮件前排除已无额度的发件人
+func loadRateBudget(tx *gorm.DB, now time.Time) (rateBudget, []domain.RateLimit, error) {
+	var limits []domain.RateLimit
+	if err := tx.Find(&limits).Error; err != nil {
+		return nil, nil, err
+	}
+
+	budget, err := newRateBudget(tx, limits, now)
+	return budget, limits, err
+}
+
+// lockRateBudget 只锁定本批邮件所属的账户、用户及SMTP服务器的限流规则并重新统计用量，
+// 避免多个agent同时获取同一发件人的邮件时超出限制，其他发件人的下发不受影响
+func lockRateBudget(tx *gorm.DB, limits []domain.RateLimit, msgs []domain.OutboundMessage, now time.Time) (rateBudget, error) {
+	keys := make(map[string]bool, len(msgs)*3)
+	for _, msg := range msgs {
+		keys[rateBudgetKey(domain.RateLimitScopeAccount, msg.FromAddr)] = true
+		keys[rateBudgetKey(domain.RateLimitScopeUser, strconv.FormatInt(msg.UserID, 10))] = true
+		keys[rateBudgetKey(domain.RateLimitScopeHost, msg.SmtpHost)] = true
+	}
+
+	var ids []int64
+	for _, limit := range limits {
+		if keys[rateBudgetKey(limit.Scope, limit.Key)] {
+			ids = append(ids, limit.ID)
+		}
+	}
+	if len(ids) == 0 {
+		return rateBudget{}, nil
+	}
+
+	// 按ID顺序加锁，避免互相等待
+	var locked []domain.RateLimit
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("id IN ?", ids).
+		Order("id").
+		Find(&locked).Error; err != nil {
+		return nil, err
+	}
+	return newRateBudget(tx, locked, now)
+}
+
+// newRateBudget 统计规则的用量并计算剩余额度
+func newRateBudget(tx *gorm.DB, limits []domain.RateLimit, now time.Time) (rateBudget, error) {
+	usages, err := rateLimitUsages(tx, limits, now)
+	if err != nil {
+		return nil, err
+	}
+
+	budget := make(rateBudget, len(usages))
+	for _, usage := range usages {
+		if remaining, ok := remainingQuota(usage); ok {
+			budget[rateBudgetKey(usage.Scope, usage.Key)] = &rateLimitState{key: usage.Key, remaining: remaining}
+		}
+	}
+	return budget, nil
+}
+
+// rateLimitColumns 各范围在下发记录中对应的字段
+var rateLimitColumns = map[string]string{
+	domain.RateLimitScopeAccount: "from_addr",
+	domain.RateLimitScopeUser:    "user_id",
+	domain.RateLimitScopeHost:    "smtp_host",
+}
+
+// rateLimitUsages 统计规则在各时间窗口内的下发数量，每个范围只查询一次
+func rateLimitUsages(tx *gorm.DB, limits []domain.RateLimit, now time.Time) ([]domain.RateLimitUsage, error) {
+	scopeKeys := make(map[string][]string)
+	for _, limit := range limits {
+		scopeKeys[limit.Scope] = append(scopeKeys[limit.Scope], limit.Key)
+	}
+
+	type windowCounts struct {
+		Key    string
+		Minute int64
+		Hour   int64
+		Day    int64
+	}
+	counts := make(map[string]windowCounts)
+	for scope, keys := range scopeKeys {
+		column, ok := rateLimitColumns[scope]
+		if !ok {
+			continue
+		}
+
+		var rows []windowCounts
+		if err := tx.Model(&domain.DispatchLog{}).
+			Select(column+" AS `key`, "+
+				"COALESCE(SUM(CASE WHEN created_at >= ? THEN 1 ELSE 0 END), 0) AS minute, "+
+				"COALESCE(SUM(CASE WHEN created_at >= ? THEN 1 ELSE 0 END), 0) AS hour, "+
+				"COUNT(*) AS day", now.Add(-time.Minute), now.Add(-time.Hour)).
+			Where("created_at >= ? AND "+column+" IN ?", now.Add(-dispatchLogRetention), keys).
+			Group(column).
+			Scan(&rows).Error; err != nil {
+			return nil, err
+		}
+		for _, row := range rows {
+			counts[rateBudgetKey(scope, row.Key)] = row
+		}
+	}
+
+	usages := make([]domain.RateLimitUsage, 0, len(limits))
+	for _, limit := range limits {
+		usage := domain.RateLimitUsage{RateLimit: limit}
+		if c, ok := counts[rateBudgetKey(limit.Scope, limit.Key)]; ok {
+			usage.UsedLastMinute = c.Minute
+			usage.UsedLastHour = c.Hour
+			usage.UsedLastDay = c.Day
+		}
+		remaining, ok := remainingQuota(usage)
+		usage.Limited = ok && remaining <= 0
+		usages = append(usages, usage)
+	}
+	return usages, nil
+}
+
+// releaseDispatch 租约到期或重新入队重试的下发不计入限流，邮件重新下发时会再次计入
+func releaseDispatch(tx *gorm.DB, leaseID string) error {
+	if leaseID == "" {
+		return nil
+	}
+	return tx.Where("lease_id = ?", leaseID).Delete(&domain.DispatchLog{}).Error
+}
+
+// remainingQuota 各时间窗口剩余额度的最小值，未设置任何窗口时返回false
+func remainingQuota(usage domain.RateLimitUsage) (int, bool) {
+	remaining, limited := math.MaxInt, false
+	for _, w := range []struct {
+		limit int
+		used  int64
+	}{
+		{usage.PerMinute, usage.UsedLastMinute},
+		{usage.PerHour, usage.UsedLastHour},
+		{usage.PerDay, usage.UsedLastDay},
+	} {
+		if w.limit <= 0 {
+			continue
+		}
+		limited = true
+		if r := w.limit - int(w.used); r < remaining {
+			remaining = r
+		}
+	}
+	return remaining, limited
+}
+
+// pruneDispatchLogs 定期删除超出最长限流窗口的下发记录
+func (q *MailQueue) pruneDispatchLogs(now time.Time) error {
+	last := q.lastPrune.Load()
+	if now.Unix()-last < int64(dispatchLogPruneInterval/time.Second) || !q.lastPrune.CompareAndSwap(last, now.Unix()) {
+		return nil
+	}
+	return q.DB.Where("created_at < ?", now.Add(-dispatchLogRetention)).Delete(&domain.DispatchLog{}).Error
+}
+
+// RateLimitUsages 统计限流规则的当前用量
+func (q *MailQueue) RateLimitUsages(limits []domain.RateLimit) ([]domain.RateLimitUsage, error) {
+	return rateLimitUsages(q.DB, limits, time.Now())
+}
+
+// SetRateLimit 新增或覆盖范围与Key相同的限流规则
+func (q *MailQueue) SetRateLimit(req domain.RateLimitReq) (domain.RateLimit, error) {
+	limit := domain.RateLimit{
+		Scope:     req.Scope,
+		Key:       strings.TrimSpace(req.Key),
+		PerMinute: req.PerMinute,
+		PerHour:   req.PerHour,
+		PerDay:    req.PerDay,
+	}
+	if limit.Scope == domain.RateLimitScopeHost {
+		limit.Key = strings.ToLower(limit.Key)
+	}
+
+	if err := q.DB.Clauses(clause.OnConflict{
+		DoUpdates: clause.AssignmentColumns([]string{"per_minute", "per_hour", "per_day", "updated_at"}),
+	}).Create(&limit).Error; err != nil {
+		return limit, err
+	}
+
+	if err := q.DB.Where("scope = ? AND `key` = ?", limit.Scope, limit.Key).First(&limit).Error; err != nil {
+		return limit, err
+	}
+
+	// 限制可能已放宽，唤醒等待中的agent
+	q.notifier.broadcast()
+	return limit, nil
+}
+
+// DeleteRateLimit 删除限流规则
+func (q *MailQueue) DeleteRateLimit(id int64) error {
+	result := q.DB.Delete(&domain.RateLimit{}, id)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrRateLimitNotFound
+	}
+
+	q.notifier.broadcast()
+	return nil
+}
@@ -0,0 +1,52 @@
+package api
+
+import (
+	"testing"
+
+	"msps/internal/app/model/domain"
+)
+
+func TestRemainingQuota(t *testing.T) {
+	usage := domain.RateLimitUsage{
+		RateLimit:      domain.RateLimit{PerMinute: 10, PerDay: 100},
+		UsedLastMinute: 4,
+		UsedLastHour:   50,
+		UsedLastDay:    98,
+	}
+	if remaining, ok := remainingQuota(usage); !ok || remaining != 2 {
+		t.Errorf("remainingQuota = %d, %v, want 2, true", remaining, ok)
+	}
+	if _, ok := remainingQuota(domain.RateLimitUsage{}); ok {
+		t.Error("limit without windows should not be limited")
+	}
+}
+
+func TestRateBudgetTake(t *testing.T) {
+	budget := rateBudget{
+		rateBudgetKey(domain.RateLimitScopeAccount, "Sender@example.com"): {key: "Sender@example.com", remaining: 2},
+		rateBudgetKey(domain.RateLimitScopeHost, "smtp.example.com"):      {key: "smtp.example.com", remaining: 1},
+	}
+	msg := domain.OutboundMessage{FromAddr: "sender@example.com", UserID: 1, SmtpHost: "smtp.example.com"}
+
+	if !budget.take(msg) {
+		t.Fatal("first message should fit the budget")
+	}
+	// SMTP服务器已无额度，账户额度不应被扣减
+	if budget.take(msg) {
+		t.Fatal("second message exceeds the host limit")
+	}
+	if got := budget[rateBudgetKey(domain.RateLimitScopeAccount, "sender@example.com")].remaining; got != 1 {
+		t.Errorf("account remaining = %d, want 1", got)
+	}
+	if hosts := budget.exhausted(domain.RateLimitScopeHost); len(hosts) != 1 || hosts[0] != "smtp.example.com" {
+		t.Errorf("exhausted hosts = %v", hosts)
+	}
+	if accounts := budget.exhausted(domain.RateLimitScopeAccount); len(accounts) != 0 {
+		t.Errorf("exhausted accounts = %v", accounts)
+	}
+
+	// 其他发件人不受限制
+	if !budget.take(domain.OutboundMessage{FromAddr: "other@example.com", SmtpHost: "smtp.other.com"}) {
+		t.Error("unrelated sender should not be limited")
+	}
+}
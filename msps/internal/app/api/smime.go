@@ -0,0 +1,162 @@
+package api
+
+import (
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"msps/internal/app/model/domain"
+)
+
+// parseSMIMECertificate 解析PEM中的第一张证书
+func parseSMIMECertificate(certPEM string) (*x509.Certificate, error) {
+	rest := []byte(certPEM)
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			return nil, errors.New("未找到PEM格式的证书")
+		}
+		if block.Type == "CERTIFICATE" {
+			return x509.ParseCertificate(block.Bytes)
+		}
+	}
+}
+
+// validateSMIMEKeyPair 校验发件账户的签名证书与私钥是否匹配且证书未过期
+func validateSMIMEKeyPair(certPEM, keyPEM string) error {
+	if _, err := tls.X509KeyPair([]byte(certPEM), []byte(keyPEM)); err != nil {
+		return err
+	}
+	cert, err := parseSMIMECertificate(certPEM)
+	if err != nil {
+		return err
+	}
+	if time.Now().After(cert.NotAfter) {
+		return errors.New("证书已过期")
+	}
+	return nil
+}
+
+// validateRecipientCertificate 校验收件人证书可用于加密，返回解析后的证书
+func validateRecipientCertificate(certPEM string) (*x509.Certificate, error) {
+	cert, err := parseSMIMECertificate(certPEM)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := cert.PublicKey.(*rsa.PublicKey); !ok {
+		return nil, errors.New("仅支持RSA证书")
+	}
+	if time.Now().After(cert.NotAfter) {
+		return nil, errors.New("证书已过期")
+	}
+	if cert.KeyUsage != 0 && cert.KeyUsage&x509.KeyUsageKeyEncipherment == 0 {
+		return nil, errors.New("证书不允许用于密钥加密")
+	}
+	return cert, nil
+}
+
+// checkSMIME 校验邮件的S/MIME选项：签名需要发件账户属于当前用户并已配置证书，
+// 加密需要所有收件人都已上传证书。不满足时返回提示信息
+func (a *Client) checkSMIME(userID int64, req domain.EmailReq) (string, error) {
+	if req.SMIME == nil {
+		return "", nil
+	}
+
+	if req.SMIME.Sign {
+		var account domain.UserMailAccount
+		if err := a.DB.Select("smime_cert", "smime_key").
+			Where("user_id = ? AND email = ?", userID, req.From.Addr).
+			First(&account).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return "发件邮箱不属于当前用户，无法签名", nil
+			}
+			return "", err
+		}
+		if account.SMIMECert == "" || account.SMIMEKey == "" {
+			return "发件账户未配置S/MIME证书", nil
+		}
+	}
+
+	if req.SMIME.Encrypt {
+		certs, err := recipientCertificates(a.DB, userID, req)
+		if err != nil {
+			return "", err
+		}
+		var missing []string
+		for _, addr := range recipientAddrs(req) {
+			if _, ok := certs[addr]; !ok {
+				missing = append(missing, addr)
+			}
+		}
+		if len(missing) > 0 {
+			return "以下收件人缺少S/MIME证书: " + strings.Join(missing, ", "), nil
+		}
+	}
+	return "", nil
+}
+
+// applySMIMEKeys 为要求S/MIME的邮件附上发件账户的签名证书和收件人证书，userIDs与reqs一一对应。
+// 证书和私钥只随本次下发返回，不写入队列中的邮件内容；缺少的证书由agent按发送失败处理，不会发出未签名或未加密的邮件
+func (q *MailQueue) applySMIMEKeys(reqs []domain.EmailReq, userIDs []int64) {
+	for i := range reqs {
+		req := &reqs[i]
+		req.SMIMEKeys = nil
+		if req.SMIME == nil || req.From == nil {
+			continue
+		}
+
+		keys := &domain.SMIMEKeys{}
+		if req.SMIME.Sign {
+			var account domain.UserMailAccount
+			if err := q.DB.Select("smime_cert", "smime_key").
+				Where("user_id = ? AND email = ?", userIDs[i], req.From.Addr).
+				First(&account).Error; err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+				log.Printf("查询 %s 的S/MIME证书失败: %v", req.From.Addr, err)
+			}
+			keys.Certificate, keys.PrivateKey = account.SMIMECert, account.SMIMEKey
+		}
+		if req.SMIME.Encrypt {
+			certs, err := recipientCertificates(q.DB, userIDs[i], *req)
+			if err != nil {
+				log.Printf("查询邮件 %s 的收件人证书失败: %v", req.ID, err)
+			}
+			keys.RecipientCerts = certs
+		}
+		req.SMIMEKeys = keys
+	}
+}
+
+// recipientCertificates 查询用户为邮件收件人保存的证书，返回小写地址到证书的映射
+func recipientCertificates(db *gorm.DB, userID int64, req domain.EmailReq) (map[string]string, error) {
+	var certs []domain.SMIMECertificate
+	if err := db.Select("email", "certificate").
+		Where("user_id = ? AND email IN ?", userID, recipientAddrs(req)).
+		Find(&certs).Error; err != nil {
+		return nil, fmt.Errorf("failed to query recipient certificates: %w", err)
+	}
+
+	result := make(map[string]string, len(certs))
+	for _, cert := range certs {
+		result[cert.Email] = cert.Certificate
+	}
+	return result, nil
+}
+
+// recipientAddrs 邮件所有收件人(含抄送、密送)的小写地址
+func recipientAddrs(req domain.EmailReq) []string {
+	var addrs []string
+	for _, list := range [][]domain.EmailAddress{req.To, req.CC, req.BCC} {
+		for _, rcpt := range list {
+			addrs = append(addrs, strings.ToLower(rcpt.Addr))
+		}
+	}
+	return addrs
+}
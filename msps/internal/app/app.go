@@ -2,6 +2,7 @@ package app
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
 	"github.com/gin-gonic/gin"
 	"net/http"
@@ -66,10 +67,17 @@ func initHttpServer(ctx context.Context, engine *gin.Engine, port uint) func() {
 		Addr:           fmt.Sprintf(":%d", port),
 		Handler:        engine,
 		MaxHeaderBytes: 1 << 20,
+		TLSConfig:      &tls.Config{MinVersion: tls.VersionTLS12},
 	}
 
 	go func() {
-		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		var err error
+		if cfg := config.GlobalConfig(); cfg.TLSEnabled() {
+			err = srv.ListenAndServeTLS(cfg.TLSCertFile, cfg.TLSKeyFile)
+		} else {
+			err = srv.ListenAndServe()
+		}
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
 			log.Errorf("HTTP server error: %v", err)
 		}
 	}()
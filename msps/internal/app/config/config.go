@@ -6,14 +6,34 @@ import (
 )
 
 type Config struct {
-	IsDebug     bool
-	HttpPort    uint
-	SwagHost    string
-	DatabaseDSN string `mapstructure:"database_dsn"`
+	IsDebug       bool
+	HttpPort      uint
+	SwagHost      string
+	DatabaseDSN   string `mapstructure:"database_dsn"`
+	LeaseTimeout  uint   `mapstructure:"lease_timeout"`  // agent租约有效期(秒)
+	PriorityAging uint   `mapstructure:"priority_aging"` // 队列中邮件每等待该时间(秒)优先级提升一级
+
+	AgentOfflineAfter uint `mapstructure:"agent_offline_after"` // 超过该时间(秒)未收到心跳的agent视为离线
+	IdempotencyWindow uint `mapstructure:"idempotency_window"`  // 幂等键有效期(秒)，有效期内重复提交不会再次入队
+	UndoSendWindow    uint `mapstructure:"undo_send_window"`    // 邮件提交后暂不下发的时间(秒)，期间可撤回，为0时立即下发
+
+	TLSCertFile string `mapstructure:"tls_cert_file"` // HTTPS证书路径，与私钥同时配置时启用HTTPS
+	TLSKeyFile  string `mapstructure:"tls_key_file"`  // HTTPS私钥路径
+
+	RetryMaxAttempts int  `mapstructure:"retry_max_attempts"` // 临时错误最多尝试发送次数
+	RetryBaseDelay   uint `mapstructure:"retry_base_delay"`   // 首次重试等待时间(秒)，之后按指数增长
+	RetryMaxDelay    uint `mapstructure:"retry_max_delay"`    // 重试等待时间上限(秒)
+
+	OAuthTrustedHosts []string `mapstructure:"oauth_trusted_hosts"` // 受信任的OAuth2令牌端点主机(host或host:port)，可使用http和内网地址
 }
 
 var globalConfig *Config
 
+// TLSEnabled 是否使用HTTPS提供服务
+func (c *Config) TLSEnabled() bool {
+	return c.TLSCertFile != "" && c.TLSKeyFile != ""
+}
+
 func GlobalConfig() *Config {
 	return globalConfig
 }
@@ -52,6 +72,9 @@ func InitConfig() error {
 	if cfg.DatabaseDSN == "" {
 		return fmt.Errorf("缺少数据库连接配置")
 	}
+	if (cfg.TLSCertFile == "") != (cfg.TLSKeyFile == "") {
+		return fmt.Errorf("tls_cert_file与tls_key_file需同时配置")
+	}
 
 	globalConfig = &cfg
 	return nil
@@ -8,6 +8,16 @@ var ProviderSet = wire.NewSet(
 		"HttpPort",
 		"SwagHost",
 		"DatabaseDSN",
+		"LeaseTimeout",
+		"PriorityAging",
+		"AgentOfflineAfter",
+		"IdempotencyWindow",
+		"UndoSendWindow",
+		"TLSCertFile",
+		"TLSKeyFile",
+		"RetryMaxAttempts",
+		"RetryBaseDelay",
+		"RetryMaxDelay",
 	),
 	InitConfig,
 	GlobalConfig,
@@ -0,0 +1,226 @@
+package controller
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"io"
+	"msps/internal/app/config"
+	"msps/internal/app/model/common"
+	"msps/internal/app/model/domain"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// defaultAgentOfflineAfter 未配置时，超过该时间未收到心跳的agent视为离线
+const defaultAgentOfflineAfter = 30 * time.Second
+
+type AgentMessagesResponse struct {
+	Messages   []domain.OutboundMessage `json:"messages"`
+	Pagination Pagination               `json:"pagination"`
+}
+
+// ListAgents
+// @Summary agent列表
+// @Description 获取所有已注册的agent及其在线状态
+// @tags Admin
+// @Produce json
+// @Success 200 {object} common.Response "{"success":true,"msg":"","data":[]}"
+// @Failure 401 {object} common.Response "{"success":false,"msg":"用户未登录","data":null}"
+// @Failure 403 {object} common.Response "{"success":false,"msg":"访问受限","data":null}"
+// @Failure 500 {object} common.Response "{"success":false,"msg":"Internal Server Error","data":null}"
+// @Router /c/admin/agents [get]
+func (ac *AdminController) ListAgents(c *gin.Context) {
+	var agents []domain.AgentNode
+	if err := ac.DB.Order("last_seen_at DESC").Find(&agents).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, common.NewResponse(common.WithMsg("获取agent列表失败")))
+		return
+	}
+
+	offlineAfter := agentOfflineAfter()
+	now := time.Now()
+	for i := range agents {
+		agents[i].Online = now.Sub(agents[i].LastSeenAt) < offlineAfter
+	}
+
+	c.JSON(http.StatusOK, common.NewResponse(common.WithSuccess(true), common.WithPayload(agents)))
+}
+
+// ListAgentMessages
+// @Summary agent处理的邮件
+// @Description 分页获取指定agent获取过或投递过的邮件
+// @tags Admin
+// @Produce json
+// @Param id path string true "agent唯一标识"
+// @Param page query int false "页码"
+// @Param limit query int false "每页数量"
+// @Success 200 {object} common.Response "{"success":true,"msg":"","data":null}"
+// @Failure 401 {object} common.Response "{"success":false,"msg":"用户未登录","data":null}"
+// @Failure 403 {object} common.Response "{"success":false,"msg":"访问受限","data":null}"
+// @Failure 404 {object} common.Response "{"success":false,"msg":"agent不存在","data":null}"
+// @Failure 500 {object} common.Response "{"success":false,"msg":"Internal Server Error","data":null}"
+// @Router /c/admin/agents/{id}/messages [get]
+func (ac *AdminController) ListAgentMessages(c *gin.Context) {
+	agentID := c.Param("id")
+
+	page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "10"))
+	if err != nil || limit < 1 || limit > 100 {
+		limit = 10
+	}
+
+	if err := ac.DB.Select("id").Where("id = ?", agentID).First(&domain.AgentNode{}).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, common.NewResponse(common.WithMsg("agent不存在")))
+			return
+		}
+		c.JSON(http.StatusInternalServerError, common.NewResponse(common.WithMsg("获取agent失败")))
+		return
+	}
+
+	// 邮件重试时可能由不同agent投递，除最近一次获取的agent外也包含投递记录中的agent
+	query := ac.DB.Model(&domain.OutboundMessage{}).
+		Where("agent_id = ? OR email_req_id IN (?)", agentID,
+			ac.DB.Model(&domain.EmailAttempt{}).Select("email_req_id").Where("agent_id = ?", agentID))
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, common.NewResponse(common.WithMsg("获取记录总数失败")))
+		return
+	}
+
+	var messages []domain.OutboundMessage
+	if err := query.Omit("payload").
+		Order("id DESC").
+		Offset((page - 1) * limit).
+		Limit(limit).
+		Find(&messages).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, common.NewResponse(common.WithMsg("获取记录失败")))
+		return
+	}
+
+	c.JSON(http.StatusOK, common.NewResponse(
+		common.WithSuccess(true),
+		common.WithPayload(AgentMessagesResponse{
+			Messages: messages,
+			Pagination: Pagination{
+				CurrentPage: page,
+				PerPage:     limit,
+				Total:       int(total),
+			},
+		}),
+	))
+}
+
+// ListAgentCredentials
+// @Summary agent凭证列表
+// @Description 获取所有已签发的agent凭证(不含密钥)
+// @tags Admin
+// @Produce json
+// @Success 200 {object} common.Response "{"success":true,"msg":"","data":[]}"
+// @Failure 401 {object} common.Response "{"success":false,"msg":"用户未登录","data":null}"
+// @Failure 403 {object} common.Response "{"success":false,"msg":"访问受限","data":null}"
+// @Failure 500 {object} common.Response "{"success":false,"msg":"Internal Server Error","data":null}"
+// @Router /c/admin/agent_credentials [get]
+func (ac *AdminController) ListAgentCredentials(c *gin.Context) {
+	var creds []domain.AgentCredential
+	if err := ac.DB.Order("created_at DESC").Find(&creds).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, common.NewResponse(common.WithMsg("获取凭证列表失败")))
+		return
+	}
+
+	c.JSON(http.StatusOK, common.NewResponse(common.WithSuccess(true), common.WithPayload(creds)))
+}
+
+// EnrollAgent
+// @Summary 签发agent凭证
+// @Description 为agent签发签名凭证，密钥仅在本次返回
+// @tags Admin
+// @Accept json
+// @Produce json
+// @Param data body domain.AgentEnrollReq false "凭证信息"
+// @Success 200 {object} common.Response "{"success":true,"msg":"","data":{"key_id":"","secret":""}}"
+// @Failure 400 {object} common.Response "{"success":false,"msg":"请求参数错误","data":null}"
+// @Failure 401 {object} common.Response "{"success":false,"msg":"用户未登录","data":null}"
+// @Failure 403 {object} common.Response "{"success":false,"msg":"访问受限","data":null}"
+// @Failure 500 {object} common.Response "{"success":false,"msg":"Internal Server Error","data":null}"
+// @Router /c/admin/agent_credentials [post]
+func (ac *AdminController) EnrollAgent(c *gin.Context) {
+	var req domain.AgentEnrollReq
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, common.NewResponse(common.WithMsg(common.MsgInvalidParam)))
+		return
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		log.Printf("生成agent密钥失败: %v", err)
+		c.JSON(http.StatusInternalServerError, common.NewResponse(common.WithMsg(common.MsgInternalServerError)))
+		return
+	}
+
+	cred := domain.AgentCredential{
+		ID:      uuid.NewString(),
+		Secret:  hex.EncodeToString(secret),
+		Name:    req.Name,
+		AgentID: req.AgentID,
+	}
+	if err := ac.DB.Create(&cred).Error; err != nil {
+		log.Printf("保存agent凭证失败: %v", err)
+		c.JSON(http.StatusInternalServerError, common.NewResponse(common.WithMsg(common.MsgInternalServerError)))
+		return
+	}
+
+	c.JSON(http.StatusOK, common.NewResponse(
+		common.WithSuccess(true),
+		common.WithPayload(domain.AgentEnrollResp{KeyID: cred.ID, Secret: cred.Secret}),
+	))
+}
+
+// RevokeAgentCredential
+// @Summary 吊销agent凭证
+// @Description 吊销后使用该凭证签名的agent请求将被拒绝
+// @tags Admin
+// @Produce json
+// @Param id path string true "凭证标识"
+// @Success 200 {object} common.Response "{"success":true,"msg":"","data":null}"
+// @Failure 401 {object} common.Response "{"success":false,"msg":"用户未登录","data":null}"
+// @Failure 403 {object} common.Response "{"success":false,"msg":"访问受限","data":null}"
+// @Failure 404 {object} common.Response "{"success":false,"msg":"凭证不存在或已吊销","data":null}"
+// @Failure 500 {object} common.Response "{"success":false,"msg":"Internal Server Error","data":null}"
+// @Router /c/admin/agent_credentials/{id} [delete]
+func (ac *AdminController) RevokeAgentCredential(c *gin.Context) {
+	result := ac.DB.Model(&domain.AgentCredential{}).
+		Where("id = ? AND revoked_at IS NULL", c.Param("id")).
+		Update("revoked_at", time.Now())
+	if result.Error != nil {
+		log.Printf("吊销agent凭证失败: %v", result.Error)
+		c.JSON(http.StatusInternalServerError, common.NewResponse(common.WithMsg(common.MsgInternalServerError)))
+		return
+	}
+
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, common.NewResponse(common.WithMsg("凭证不存在或已吊销")))
+		return
+	}
+
+	c.JSON(http.StatusOK, common.NewResponse(common.WithSuccess(true)))
+}
+
+// agentOfflineAfter 超过该时间未收到心跳的agent视为离线
+func agentOfflineAfter() time.Duration {
+	if cfg := config.GlobalConfig(); cfg != nil && cfg.AgentOfflineAfter > 0 {
+		return time.Duration(cfg.AgentOfflineAfter) * time.Second
+	}
+	return defaultAgentOfflineAfter
+}
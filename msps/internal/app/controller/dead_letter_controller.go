@@ -0,0 +1,233 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"msps/internal/app/api"
+	"msps/internal/app/model/common"
+	"msps/internal/app/model/domain"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type DeadLettersResponse struct {
+	DeadLetters []domain.DeadLetter `json:"dead_letters"`
+	Pagination  Pagination          `json:"pagination"`
+}
+
+// ListDeadLetters
+// @Summary 死信列表
+// @Description 分页获取多次发送失败的邮件，默认只返回待处理的死信
+// @tags Admin
+// @Produce json
+// @Param status query string false "状态(pending/requeued/discarded)，默认pending"
+// @Param page query int false "页码"
+// @Param limit query int false "每页数量"
+// @Success 200 {object} common.Response "{"success":true,"msg":"","data":null}"
+// @Failure 401 {object} common.Response "{"success":false,"msg":"用户未登录","data":null}"
+// @Failure 403 {object} common.Response "{"success":false,"msg":"访问受限","data":null}"
+// @Failure 500 {object} common.Response "{"success":false,"msg":"Internal Server Error","data":null}"
+// @Router /c/admin/dead_letters [get]
+func (ac *AdminController) ListDeadLetters(c *gin.Context) {
+	status := c.DefaultQuery("status", domain.DeadLetterStatusPending)
+
+	page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "10"))
+	if err != nil || limit < 1 || limit > 100 {
+		limit = 10
+	}
+
+	query := ac.DB.Model(&domain.DeadLetter{}).Where("status = ?", status)
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, common.NewResponse(common.WithMsg("获取记录总数失败")))
+		return
+	}
+
+	var letters []domain.DeadLetter
+	if err := query.Omit("payload").
+		Order("id DESC").
+		Offset((page - 1) * limit).
+		Limit(limit).
+		Find(&letters).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, common.NewResponse(common.WithMsg("获取记录失败")))
+		return
+	}
+
+	c.JSON(http.StatusOK, common.NewResponse(
+		common.WithSuccess(true),
+		common.WithPayload(DeadLettersResponse{
+			DeadLetters: letters,
+			Pagination: Pagination{
+				CurrentPage: page,
+				PerPage:     limit,
+				Total:       int(total),
+			},
+		}),
+	))
+}
+
+// GetDeadLetter
+// @Summary 死信详情
+// @Description 获取死信及原始邮件请求，SMTP密码不会返回
+// @tags Admin
+// @Produce json
+// @Param id path int true "死信ID"
+// @Success 200 {object} common.Response "{"success":true,"msg":"","data":null}"
+// @Failure 401 {object} common.Response "{"success":false,"msg":"用户未登录","data":null}"
+// @Failure 403 {object} common.Response "{"success":false,"msg":"访问受限","data":null}"
+// @Failure 404 {object} common.Response "{"success":false,"msg":"死信不存在","data":null}"
+// @Failure 500 {object} common.Response "{"success":false,"msg":"Internal Server Error","data":null}"
+// @Router /c/admin/dead_letters/{id} [get]
+func (ac *AdminController) GetDeadLetter(c *gin.Context) {
+	var detail domain.DeadLetterDetail
+	if err := ac.DB.First(&detail.DeadLetter, c.Param("id")).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, common.NewResponse(common.WithMsg("死信不存在")))
+			return
+		}
+		c.JSON(http.StatusInternalServerError, common.NewResponse(common.WithMsg("获取记录失败")))
+		return
+	}
+
+	if err := json.Unmarshal([]byte(detail.Payload), &detail.Request); err != nil {
+		log.Printf("解析死信 %d 失败: %v", detail.ID, err)
+		c.JSON(http.StatusInternalServerError, common.NewResponse(common.WithMsg(common.MsgInternalServerError)))
+		return
+	}
+	if detail.Request.Auth != nil {
+		detail.Request.Auth.Pass = ""
+	}
+	detail.Request.Lease = nil
+
+	c.JSON(http.StatusOK, common.NewResponse(common.WithSuccess(true), common.WithPayload(detail)))
+}
+
+// UpdateDeadLetter
+// @Summary 修改死信
+// @Description 修改待处理死信的收件人、SMTP服务器或认证信息，未提供的字段保持不变
+// @tags Admin
+// @Accept json
+// @Produce json
+// @Param id path int true "死信ID"
+// @Param data body domain.DeadLetterUpdateReq true "修改内容"
+// @Success 200 {object} common.Response "{"success":true,"msg":"","data":null}"
+// @Failure 400 {object} common.Response "{"success":false,"msg":"请求参数错误","data":null}"
+// @Failure 401 {object} common.Response "{"success":false,"msg":"用户未登录","data":null}"
+// @Failure 403 {object} common.Response "{"success":false,"msg":"访问受限","data":null}"
+// @Failure 404 {object} common.Response "{"success":false,"msg":"死信不存在或已处理","data":null}"
+// @Failure 500 {object} common.Response "{"success":false,"msg":"Internal Server Error","data":null}"
+// @Router /c/admin/dead_letters/{id} [put]
+func (ac *AdminController) UpdateDeadLetter(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, common.NewResponse(common.WithMsg(common.MsgInvalidParam)))
+		return
+	}
+
+	var req domain.DeadLetterUpdateReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, common.NewResponse(common.WithMsg(common.MsgInvalidParam)))
+		return
+	}
+	if req.Auth != nil && !req.Auth.ValidMechanism() {
+		c.JSON(http.StatusBadRequest, common.NewResponse(common.WithMsg("不支持的SMTP认证方式")))
+		return
+	}
+	if req.Server != nil && !req.Server.ValidTLS() {
+		c.JSON(http.StatusBadRequest, common.NewResponse(common.WithMsg("SMTP加密设置错误")))
+		return
+	}
+
+	if err := ac.Queue.UpdateDeadLetter(id, req); err != nil {
+		ac.deadLetterError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, common.NewResponse(common.WithSuccess(true)))
+}
+
+// RequeueDeadLetter
+// @Summary 死信重新入队
+// @Description 使用死信中的邮件请求重新入队，尝试次数清零
+// @tags Admin
+// @Produce json
+// @Param id path int true "死信ID"
+// @Success 200 {object} common.Response "{"success":true,"msg":"","data":null}"
+// @Failure 400 {object} common.Response "{"success":false,"msg":"请求参数错误","data":null}"
+// @Failure 401 {object} common.Response "{"success":false,"msg":"用户未登录","data":null}"
+// @Failure 403 {object} common.Response "{"success":false,"msg":"访问受限","data":null}"
+// @Failure 404 {object} common.Response "{"success":false,"msg":"死信不存在或已处理","data":null}"
+// @Failure 500 {object} common.Response "{"success":false,"msg":"Internal Server Error","data":null}"
+// @Router /c/admin/dead_letters/{id}/requeue [post]
+func (ac *AdminController) RequeueDeadLetter(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, common.NewResponse(common.WithMsg(common.MsgInvalidParam)))
+		return
+	}
+
+	req, err := ac.Queue.RequeueDeadLetter(id)
+	if err != nil {
+		ac.deadLetterError(c, err)
+		return
+	}
+
+	// 邮件记录恢复为pending，由重新发送的结果决定最终状态
+	if err := ac.Client.SyncEmailRecords(req); err != nil {
+		log.Printf("同步邮件记录失败: %v", err)
+	}
+
+	c.JSON(http.StatusOK, common.NewResponse(common.WithSuccess(true)))
+}
+
+// DiscardDeadLetter
+// @Summary 丢弃死信
+// @Description 丢弃待处理的死信，邮件不再发送
+// @tags Admin
+// @Produce json
+// @Param id path int true "死信ID"
+// @Success 200 {object} common.Response "{"success":true,"msg":"","data":null}"
+// @Failure 400 {object} common.Response "{"success":false,"msg":"请求参数错误","data":null}"
+// @Failure 401 {object} common.Response "{"success":false,"msg":"用户未登录","data":null}"
+// @Failure 403 {object} common.Response "{"success":false,"msg":"访问受限","data":null}"
+// @Failure 404 {object} common.Response "{"success":false,"msg":"死信不存在或已处理","data":null}"
+// @Failure 500 {object} common.Response "{"success":false,"msg":"Internal Server Error","data":null}"
+// @Router /c/admin/dead_letters/{id} [delete]
+func (ac *AdminController) DiscardDeadLetter(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, common.NewResponse(common.WithMsg(common.MsgInvalidParam)))
+		return
+	}
+
+	if err := ac.Queue.DiscardDeadLetter(id); err != nil {
+		ac.deadLetterError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, common.NewResponse(common.WithSuccess(true)))
+}
+
+// deadLetterError 返回死信操作的错误
+func (ac *AdminController) deadLetterError(c *gin.Context, err error) {
+	if errors.Is(err, api.ErrDeadLetterNotFound) {
+		c.JSON(http.StatusNotFound, common.NewResponse(common.WithMsg("死信不存在或已处理")))
+		return
+	}
+	if errors.Is(err, domain.ErrAuthTLSMismatch) {
+		c.JSON(http.StatusBadRequest, common.NewResponse(common.WithMsg(err.Error())))
+		return
+	}
+	log.Printf("处理死信失败: %v", err)
+	c.JSON(http.StatusInternalServerError, common.NewResponse(common.WithMsg(common.MsgInternalServerError)))
+}
@@ -0,0 +1,468 @@
+package controller
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"msps/internal/app/model/common"
+	"msps/internal/app/model/domain"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// dkimRSAKeyBits 生成的RSA密钥长度
+	dkimRSAKeyBits = 2048
+	// dkimMinRSAKeyBits 上传的RSA密钥最小长度，RFC 8301要求验证方拒绝短于1024位的密钥
+	dkimMinRSAKeyBits = 1024
+	// dkimTXTChunkSize DNS TXT记录单个字符串的最大长度
+	dkimTXTChunkSize = 255
+)
+
+// dnsLabelsPattern 由字母、数字和连字符组成、以点分隔的域名或选择器
+var dnsLabelsPattern = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?(\.[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?)*$`)
+
+// ListDKIMKeys
+// @Summary DKIM密钥列表
+// @Description 获取所有发件域名的DKIM密钥(不含私钥)
+// @tags Admin
+// @Produce json
+// @Param domain query string false "发件域名"
+// @Success 200 {object} common.Response "{"success":true,"msg":"","data":[]}"
+// @Failure 401 {object} common.Response "{"success":false,"msg":"用户未登录","data":null}"
+// @Failure 403 {object} common.Response "{"success":false,"msg":"访问受限","data":null}"
+// @Failure 500 {object} common.Response "{"success":false,"msg":"Internal Server Error","data":null}"
+// @Router /c/admin/dkim_keys [get]
+func (ac *AdminController) ListDKIMKeys(c *gin.Context) {
+	query := ac.DB.Order("domain, id DESC")
+	if d := c.Query("domain"); d != "" {
+		query = query.Where("domain = ?", normalizeDKIMName(d))
+	}
+
+	var keys []domain.DKIMKey
+	if err := query.Find(&keys).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, common.NewResponse(common.WithMsg("获取DKIM密钥失败")))
+		return
+	}
+
+	c.JSON(http.StatusOK, common.NewResponse(common.WithSuccess(true), common.WithPayload(keys)))
+}
+
+// CreateDKIMKey
+// @Summary 上传或生成DKIM密钥
+// @Description 上传发件域名的DKIM私钥，未提供私钥时由msps生成。返回需要发布的DNS TXT记录，私钥不会再次返回
+// @tags Admin
+// @Accept json
+// @Produce json
+// @Param data body domain.DKIMKeyReq true "DKIM密钥"
+// @Success 200 {object} common.Response "{"success":true,"msg":"","data":{"id":1,"dns":{}}}"
+// @Failure 400 {object} common.Response "{"success":false,"msg":"请求参数错误","data":null}"
+// @Failure 401 {object} common.Response "{"success":false,"msg":"用户未登录","data":null}"
+// @Failure 403 {object} common.Response "{"success":false,"msg":"访问受限","data":null}"
+// @Failure 409 {object} common.Response "{"success":false,"msg":"该域名已存在相同选择器的密钥","data":null}"
+// @Failure 500 {object} common.Response "{"success":false,"msg":"Internal Server Error","data":null}"
+// @Router /c/admin/dkim_keys [post]
+func (ac *AdminController) CreateDKIMKey(c *gin.Context) {
+	var req domain.DKIMKeyReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, common.NewResponse(common.WithMsg(common.MsgInvalidParam)))
+		return
+	}
+
+	key := domain.DKIMKey{
+		Domain:   normalizeDKIMName(req.Domain),
+		Selector: normalizeDKIMName(req.Selector),
+		Status:   req.Status,
+	}
+	if !dnsLabelsPattern.MatchString(key.Domain) || !dnsLabelsPattern.MatchString(key.Selector) {
+		c.JSON(http.StatusBadRequest, common.NewResponse(common.WithMsg("域名或选择器格式错误")))
+		return
+	}
+	if key.Status == "" {
+		key.Status = domain.DKIMKeyStatusActive
+	}
+
+	var err error
+	if req.PrivateKey != "" {
+		key.PrivateKey, key.Algorithm, err = parseDKIMPrivateKey(req.PrivateKey)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, common.NewResponse(common.WithMsg("私钥格式错误: "+err.Error())))
+			return
+		}
+	} else {
+		key.Algorithm = req.Algorithm
+		if key.Algorithm == "" {
+			key.Algorithm = domain.DKIMAlgorithmRSA
+		}
+		if key.PrivateKey, err = generateDKIMPrivateKey(key.Algorithm); err != nil {
+			log.Printf("生成DKIM密钥失败: %v", err)
+			c.JSON(http.StatusInternalServerError, common.NewResponse(common.WithMsg(common.MsgInternalServerError)))
+			return
+		}
+	}
+
+	record, err := dkimDNSRecord(key)
+	if err != nil {
+		log.Printf("生成DKIM DNS记录失败: %v", err)
+		c.JSON(http.StatusInternalServerError, common.NewResponse(common.WithMsg(common.MsgInternalServerError)))
+		return
+	}
+
+	if err := ac.DB.Where("domain = ? AND selector = ?", key.Domain, key.Selector).First(&domain.DKIMKey{}).Error; err == nil {
+		c.JSON(http.StatusConflict, common.NewResponse(common.WithMsg("该域名已存在相同选择器的密钥")))
+		return
+	}
+	if err := ac.DB.Create(&key).Error; err != nil {
+		log.Printf("保存DKIM密钥失败: %v", err)
+		c.JSON(http.StatusInternalServerError, common.NewResponse(common.WithMsg(common.MsgInternalServerError)))
+		return
+	}
+
+	c.JSON(http.StatusOK, common.NewResponse(
+		common.WithSuccess(true),
+		common.WithPayload(domain.DKIMKeyResp{DKIMKey: key, DNS: record}),
+	))
+}
+
+// GetDKIMRecord
+// @Summary DKIM DNS记录
+// @Description 获取DKIM密钥需要在发件域名下发布的DNS TXT记录
+// @tags Admin
+// @Produce json
+// @Param id path int true "密钥ID"
+// @Success 200 {object} common.Response "{"success":true,"msg":"","data":{"name":"","type":"TXT","value":"","zone":""}}"
+// @Failure 400 {object} common.Response "{"success":false,"msg":"请求参数错误","data":null}"
+// @Failure 401 {object} common.Response "{"success":false,"msg":"用户未登录","data":null}"
+// @Failure 403 {object} common.Response "{"success":false,"msg":"访问受限","data":null}"
+// @Failure 404 {object} common.Response "{"success":false,"msg":"DKIM密钥不存在","data":null}"
+// @Failure 500 {object} common.Response "{"success":false,"msg":"Internal Server Error","data":null}"
+// @Router /c/admin/dkim_keys/{id}/dns [get]
+func (ac *AdminController) GetDKIMRecord(c *gin.Context) {
+	key, ok := ac.findDKIMKey(c)
+	if !ok {
+		return
+	}
+
+	record, err := dkimDNSRecord(key)
+	if err != nil {
+		log.Printf("生成DKIM DNS记录失败: %v", err)
+		c.JSON(http.StatusInternalServerError, common.NewResponse(common.WithMsg(common.MsgInternalServerError)))
+		return
+	}
+
+	c.JSON(http.StatusOK, common.NewResponse(common.WithSuccess(true), common.WithPayload(record)))
+}
+
+// UpdateDKIMKey
+// @Summary 启用或停用DKIM密钥
+// @Description 停用的密钥不再用于签名，同一域名有多个启用的密钥时使用最新的一个
+// @tags Admin
+// @Accept json
+// @Produce json
+// @Param id path int true "密钥ID"
+// @Param data body domain.DKIMKeyUpdateReq true "密钥状态"
+// @Success 200 {object} common.Response "{"success":true,"msg":"","data":null}"
+// @Failure 400 {object} common.Response "{"success":false,"msg":"请求参数错误","data":null}"
+// @Failure 401 {object} common.Response "{"success":false,"msg":"用户未登录","data":null}"
+// @Failure 403 {object} common.Response "{"success":false,"msg":"访问受限","data":null}"
+// @Failure 404 {object} common.Response "{"success":false,"msg":"DKIM密钥不存在","data":null}"
+// @Failure 500 {object} common.Response "{"success":false,"msg":"Internal Server Error","data":null}"
+// @Router /c/admin/dkim_keys/{id} [put]
+func (ac *AdminController) UpdateDKIMKey(c *gin.Context) {
+	var req domain.DKIMKeyUpdateReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, common.NewResponse(common.WithMsg(common.MsgInvalidParam)))
+		return
+	}
+
+	key, ok := ac.findDKIMKey(c)
+	if !ok {
+		return
+	}
+
+	if err := ac.DB.Model(&key).Update("status", req.Status).Error; err != nil {
+		log.Printf("更新DKIM密钥失败: %v", err)
+		c.JSON(http.StatusInternalServerError, common.NewResponse(common.WithMsg(common.MsgInternalServerError)))
+		return
+	}
+
+	c.JSON(http.StatusOK, common.NewResponse(common.WithSuccess(true)))
+}
+
+// DeleteDKIMKey
+// @Summary 删除DKIM密钥
+// @Description 删除后该密钥不再用于签名，DNS记录可在已发送邮件验证完成后移除
+// @tags Admin
+// @Produce json
+// @Param id path int true "密钥ID"
+// @Success 200 {object} common.Response "{"success":true,"msg":"","data":null}"
+// @Failure 400 {object} common.Response "{"success":false,"msg":"请求参数错误","data":null}"
+// @Failure 401 {object} common.Response "{"success":false,"msg":"用户未登录","data":null}"
+// @Failure 403 {object} common.Response "{"success":false,"msg":"访问受限","data":null}"
+// @Failure 404 {object} common.Response "{"success":false,"msg":"DKIM密钥不存在","data":null}"
+// @Failure 500 {object} common.Response "{"success":false,"msg":"Internal Server Error","data":null}"
+// @Router /c/admin/dkim_keys/{id} [delete]
+func (ac *AdminController) DeleteDKIMKey(c *gin.Context) {
+	key, ok := ac.findDKIMKey(c)
+	if !ok {
+		return
+	}
+
+	if err := ac.DB.Delete(&key).Error; err != nil {
+		log.Printf("删除DKIM密钥失败: %v", err)
+		c.JSON(http.StatusInternalServerError, common.NewResponse(common.WithMsg(common.MsgInternalServerError)))
+		return
+	}
+
+	c.JSON(http.StatusOK, common.NewResponse(common.WithSuccess(true)))
+}
+
+// ListDKIMGrants
+// @Summary DKIM签名授权列表
+// @Description 获取可以使用发件域名DKIM密钥签名的用户
+// @tags Admin
+// @Produce json
+// @Param domain query string false "发件域名"
+// @Success 200 {object} common.Response "{"success":true,"msg":"","data":[]}"
+// @Failure 401 {object} common.Response "{"success":false,"msg":"用户未登录","data":null}"
+// @Failure 403 {object} common.Response "{"success":false,"msg":"访问受限","data":null}"
+// @Failure 500 {object} common.Response "{"success":false,"msg":"Internal Server Error","data":null}"
+// @Router /c/admin/dkim_grants [get]
+func (ac *AdminController) ListDKIMGrants(c *gin.Context) {
+	query := ac.DB.Order("domain, user_id")
+	if d := c.Query("domain"); d != "" {
+		query = query.Where("domain = ?", normalizeDKIMName(d))
+	}
+
+	var grants []domain.DKIMGrant
+	if err := query.Find(&grants).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, common.NewResponse(common.WithMsg("获取DKIM签名授权失败")))
+		return
+	}
+
+	c.JSON(http.StatusOK, common.NewResponse(common.WithSuccess(true), common.WithPayload(grants)))
+}
+
+// CreateDKIMGrant
+// @Summary 授权用户使用DKIM签名
+// @Description 邮箱账户由用户自行添加，确认用户确实拥有该域名的邮箱后授权，此后该用户从该域名发出的邮件才会签名
+// @tags Admin
+// @Accept json
+// @Produce json
+// @Param data body domain.DKIMGrantReq true "签名授权"
+// @Success 200 {object} common.Response "{"success":true,"msg":"","data":{"id":1}}"
+// @Failure 400 {object} common.Response "{"success":false,"msg":"请求参数错误","data":null}"
+// @Failure 401 {object} common.Response "{"success":false,"msg":"用户未登录","data":null}"
+// @Failure 403 {object} common.Response "{"success":false,"msg":"访问受限","data":null}"
+// @Failure 404 {object} common.Response "{"success":false,"msg":"用户不存在","data":null}"
+// @Failure 409 {object} common.Response "{"success":false,"msg":"该用户已获得授权","data":null}"
+// @Failure 500 {object} common.Response "{"success":false,"msg":"Internal Server Error","data":null}"
+// @Router /c/admin/dkim_grants [post]
+func (ac *AdminController) CreateDKIMGrant(c *gin.Context) {
+	var req domain.DKIMGrantReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, common.NewResponse(common.WithMsg(common.MsgInvalidParam)))
+		return
+	}
+
+	grant := domain.DKIMGrant{Domain: normalizeDKIMName(req.Domain), UserID: req.UserID}
+	if !dnsLabelsPattern.MatchString(grant.Domain) {
+		c.JSON(http.StatusBadRequest, common.NewResponse(common.WithMsg("域名格式错误")))
+		return
+	}
+
+	if err := ac.DB.First(&domain.User{}, req.UserID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, common.NewResponse(common.WithMsg("用户不存在")))
+			return
+		}
+		log.Printf("查询用户失败: %v", err)
+		c.JSON(http.StatusInternalServerError, common.NewResponse(common.WithMsg(common.MsgInternalServerError)))
+		return
+	}
+
+	if err := ac.DB.Where("domain = ? AND user_id = ?", grant.Domain, grant.UserID).First(&domain.DKIMGrant{}).Error; err == nil {
+		c.JSON(http.StatusConflict, common.NewResponse(common.WithMsg("该用户已获得授权")))
+		return
+	}
+	if err := ac.DB.Create(&grant).Error; err != nil {
+		log.Printf("保存DKIM签名授权失败: %v", err)
+		c.JSON(http.StatusInternalServerError, common.NewResponse(common.WithMsg(common.MsgInternalServerError)))
+		return
+	}
+
+	c.JSON(http.StatusOK, common.NewResponse(common.WithSuccess(true), common.WithPayload(grant)))
+}
+
+// DeleteDKIMGrant
+// @Summary 取消DKIM签名授权
+// @Description 取消后该用户的邮件不再签名，已下发的邮件不受影响
+// @tags Admin
+// @Produce json
+// @Param id path int true "授权ID"
+// @Success 200 {object} common.Response "{"success":true,"msg":"","data":null}"
+// @Failure 400 {object} common.Response "{"success":false,"msg":"请求参数错误","data":null}"
+// @Failure 401 {object} common.Response "{"success":false,"msg":"用户未登录","data":null}"
+// @Failure 403 {object} common.Response "{"success":false,"msg":"访问受限","data":null}"
+// @Failure 404 {object} common.Response "{"success":false,"msg":"DKIM签名授权不存在","data":null}"
+// @Failure 500 {object} common.Response "{"success":false,"msg":"Internal Server Error","data":null}"
+// @Router /c/admin/dkim_grants/{id} [delete]
+func (ac *AdminController) DeleteDKIMGrant(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, common.NewResponse(common.WithMsg(common.MsgInvalidParam)))
+		return
+	}
+
+	result := ac.DB.Delete(&domain.DKIMGrant{}, id)
+	if result.Error != nil {
+		log.Printf("删除DKIM签名授权失败: %v", result.Error)
+		c.JSON(http.StatusInternalServerError, common.NewResponse(common.WithMsg(common.MsgInternalServerError)))
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, common.NewResponse(common.WithMsg("DKIM签名授权不存在")))
+		return
+	}
+
+	c.JSON(http.StatusOK, common.NewResponse(common.WithSuccess(true)))
+}
+
+// findDKIMKey 按路径中的ID查询DKIM密钥，失败时已写入响应
+func (ac *AdminController) findDKIMKey(c *gin.Context) (domain.DKIMKey, bool) {
+	var key domain.DKIMKey
+
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, common.NewResponse(common.WithMsg(common.MsgInvalidParam)))
+		return key, false
+	}
+
+	if err := ac.DB.First(&key, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, common.NewResponse(common.WithMsg("DKIM密钥不存在")))
+			return key, false
+		}
+		log.Printf("查询DKIM密钥失败: %v", err)
+		c.JSON(http.StatusInternalServerError, common.NewResponse(common.WithMsg(common.MsgInternalServerError)))
+		return key, false
+	}
+	return key, true
+}
+
+// normalizeDKIMName 域名和选择器不区分大小写，去掉末尾的点
+func normalizeDKIMName(name string) string {
+	return strings.TrimSuffix(strings.ToLower(strings.TrimSpace(name)), ".")
+}
+
+// parseDKIMPrivateKey 解析PKCS#1或PKCS#8格式的RSA/Ed25519私钥，统一转换为PKCS#8 PEM
+func parseDKIMPrivateKey(pemKey string) (string, string, error) {
+	block, _ := pem.Decode([]byte(pemKey))
+	if block == nil {
+		return "", "", errors.New("不是PEM格式")
+	}
+
+	var key crypto.PrivateKey
+	var err error
+	if block.Type == "RSA PRIVATE KEY" {
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	} else {
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return "", "", err
+	}
+
+	var algorithm string
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		if k.N.BitLen() < dkimMinRSAKeyBits {
+			return "", "", errors.New("RSA密钥长度不能小于1024位")
+		}
+		algorithm = domain.DKIMAlgorithmRSA
+	case ed25519.PrivateKey:
+		algorithm = domain.DKIMAlgorithmEd25519
+	default:
+		return "", "", errors.New("仅支持RSA或Ed25519密钥")
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return "", "", err
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})), algorithm, nil
+}
+
+// generateDKIMPrivateKey 生成指定算法的私钥(PKCS#8 PEM)
+func generateDKIMPrivateKey(algorithm string) (string, error) {
+	var key crypto.PrivateKey
+	var err error
+	if algorithm == domain.DKIMAlgorithmEd25519 {
+		_, key, err = ed25519.GenerateKey(rand.Reader)
+	} else {
+		key, err = rsa.GenerateKey(rand.Reader, dkimRSAKeyBits)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return "", err
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})), nil
+}
+
+// dkimDNSRecord 根据私钥生成DKIM TXT记录(RFC 6376 3.6.1，Ed25519见RFC 8463)
+func dkimDNSRecord(key domain.DKIMKey) (domain.DKIMDNSRecord, error) {
+	block, _ := pem.Decode([]byte(key.PrivateKey))
+	if block == nil {
+		return domain.DKIMDNSRecord{}, errors.New("invalid private key PEM")
+	}
+	privateKey, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return domain.DKIMDNSRecord{}, err
+	}
+
+	var publicKey []byte
+	switch k := privateKey.(type) {
+	case *rsa.PrivateKey:
+		// RSA公钥使用SubjectPublicKeyInfo编码
+		if publicKey, err = x509.MarshalPKIXPublicKey(&k.PublicKey); err != nil {
+			return domain.DKIMDNSRecord{}, err
+		}
+	case ed25519.PrivateKey:
+		// Ed25519公钥直接使用32字节原始公钥
+		publicKey = k.Public().(ed25519.PublicKey)
+	default:
+		return domain.DKIMDNSRecord{}, errors.New("unsupported private key type")
+	}
+
+	name := key.Selector + "._domainkey." + key.Domain
+	value := "v=DKIM1; k=" + key.Algorithm + "; p=" + base64.StdEncoding.EncodeToString(publicKey)
+
+	var chunks []string
+	for rest := value; rest != ""; {
+		n := min(len(rest), dkimTXTChunkSize)
+		chunks = append(chunks, `"`+rest[:n]+`"`)
+		rest = rest[n:]
+	}
+
+	return domain.DKIMDNSRecord{
+		Name:  name,
+		Type:  "TXT",
+		Value: value,
+		Zone:  name + ". IN TXT ( " + strings.Join(chunks, " ") + " )",
+	}, nil
+}
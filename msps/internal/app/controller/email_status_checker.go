@@ -1,6 +1,7 @@
 package controller
 
 import (
+	"errors"
 	"gorm.io/gorm"
 	"log"
 	"msps/internal/app/api"
@@ -64,13 +65,32 @@ func (esc *EmailStatusChecker) checkPendingEmails() {
 			updateFields["status"] = "fail"
 			updateFields["sent_at"] = time.Now()
 		case api.StatusUnknown:
-			// 更新重试次数
-			updateFields["retry_count"] = record.RetryCount + 1
-
-			// 如果重试超过一定次数，标记为失败
-			if record.RetryCount >= 10 { // 假设最大重试10次
+			queueStatus, err := esc.queueStatus(record.EmailReqID)
+			if err != nil {
+				// 无法确认邮件是否仍在队列中，本轮跳过，避免误计重试次数
+				log.Printf("Failed to query queue status of %s: %v", record.EmailReqID, err)
+				continue
+			}
+			switch queueStatus {
+			case domain.OutboundStatusQueued, domain.OutboundStatusDispatched:
+				// 邮件仍在队列中或租约未结束，租约到期会自动重新入队，不计入重试次数
+			case domain.OutboundStatusSent:
+				updateFields["status"] = "success"
+				updateFields["sent_at"] = time.Now()
+			case domain.OutboundStatusFailed:
 				updateFields["status"] = "fail"
 				updateFields["sent_at"] = time.Now()
+			case domain.OutboundStatusCancelled:
+				updateFields["status"] = "cancelled"
+			default:
+				// 更新重试次数
+				updateFields["retry_count"] = record.RetryCount + 1
+
+				// 如果重试超过一定次数，标记为失败
+				if record.RetryCount >= 10 { // 假设最大重试10次
+					updateFields["status"] = "fail"
+					updateFields["sent_at"] = time.Now()
+				}
 			}
 		}
 
@@ -82,3 +102,18 @@ func (esc *EmailStatusChecker) checkPendingEmails() {
 		}
 	}
 }
+
+// queueStatus 查询邮件在持久化队列中的状态，不存在时返回空字符串
+func (esc *EmailStatusChecker) queueStatus(emailReqID string) (string, error) {
+	var msg domain.OutboundMessage
+	if err := esc.db.Select("status").
+		Where("email_req_id = ?", emailReqID).
+		Order("id DESC").
+		First(&msg).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return "", nil
+		}
+		return "", err
+	}
+	return msg.Status, nil
+}
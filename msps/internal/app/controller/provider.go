@@ -12,6 +12,7 @@ var ProviderSet = wire.NewSet(
 	NewClient,
 	NewAgent,
 	NewEmailController,
+	NewAdminController,
 	wire.Bind(new(UserControllerInterface), new(*UserController)),
 	wire.Bind(new(EmailControllerInterface), new(*EmailController)),
 )
@@ -38,6 +39,13 @@ type EmailController struct {
 	StatusChecker  *EmailStatusChecker
 }
 
+// AdminController 管理员接口
+type AdminController struct {
+	DB     *gorm.DB
+	Queue  *api.MailQueue
+	Client *api.Client
+}
+
 func NewUserController(db *gorm.DB) *UserController {
 	return &UserController{DB: db}
 }
@@ -56,9 +64,14 @@ func NewEmailController(
 	}
 }
 
-func NewClient(db *gorm.DB, userCtrl UserControllerInterface) *api.Client {
+func NewAdminController(db *gorm.DB, queue *api.MailQueue, client *api.Client) *AdminController {
+	return &AdminController{DB: db, Queue: queue, Client: client}
+}
+
+func NewClient(db *gorm.DB, userCtrl UserControllerInterface, queue *api.MailQueue) *api.Client {
 	client := &api.Client{
-		DB: db,
+		DB:    db,
+		Queue: queue,
 	}
 
 	// 初始化并启动状态检查器
@@ -68,8 +81,8 @@ func NewClient(db *gorm.DB, userCtrl UserControllerInterface) *api.Client {
 	return client
 }
 
-func NewAgent() *api.Agent {
-	return &api.Agent{}
+func NewAgent(db *gorm.DB, queue *api.MailQueue) *api.Agent {
+	return &api.Agent{DB: db, Queue: queue}
 }
 
 // HandleSentEmail 实现 EmailControllerInterface 接口方法
@@ -0,0 +1,106 @@
+package controller
+
+import (
+	"errors"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"msps/internal/app/model/common"
+	"msps/internal/app/model/domain"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// QueueStats
+// @Summary 队列概况
+// @Description 获取队列深度、最早邮件等待时间、租约、吞吐量，以及按发件账户和SMTP服务器的分布和暂停情况
+// @tags Admin
+// @Produce json
+// @Success 200 {object} common.Response "{"success":true,"msg":"","data":null}"
+// @Failure 401 {object} common.Response "{"success":false,"msg":"用户未登录","data":null}"
+// @Failure 403 {object} common.Response "{"success":false,"msg":"访问受限","data":null}"
+// @Failure 500 {object} common.Response "{"success":false,"msg":"Internal Server Error","data":null}"
+// @Router /c/admin/queue/stats [get]
+func (ac *AdminController) QueueStats(c *gin.Context) {
+	stats, err := ac.Queue.Stats()
+	if err != nil {
+		log.Printf("统计队列失败: %v", err)
+		c.JSON(http.StatusInternalServerError, common.NewResponse(common.WithMsg(common.MsgInternalServerError)))
+		return
+	}
+
+	c.JSON(http.StatusOK, common.NewResponse(common.WithSuccess(true), common.WithPayload(stats)))
+}
+
+// PauseQueue
+// @Summary 暂停下发
+// @Description 暂停指定发件账户的邮件下发，未指定发件人时暂停全部下发。已下发的邮件不受影响
+// @tags Admin
+// @Accept json
+// @Produce json
+// @Param data body domain.QueuePauseReq false "发件账户及原因"
+// @Success 200 {object} common.Response "{"success":true,"msg":"","data":null}"
+// @Failure 400 {object} common.Response "{"success":false,"msg":"请求参数错误","data":null}"
+// @Failure 401 {object} common.Response "{"success":false,"msg":"用户未登录","data":null}"
+// @Failure 403 {object} common.Response "{"success":false,"msg":"访问受限","data":null}"
+// @Failure 500 {object} common.Response "{"success":false,"msg":"Internal Server Error","data":null}"
+// @Router /c/admin/queue/pause [post]
+func (ac *AdminController) PauseQueue(c *gin.Context) {
+	req, ok := bindQueuePauseReq(c)
+	if !ok {
+		return
+	}
+
+	if err := ac.Queue.Pause(req.FromAddr, req.Reason, c.GetString("username")); err != nil {
+		log.Printf("暂停下发失败: %v", err)
+		c.JSON(http.StatusInternalServerError, common.NewResponse(common.WithMsg(common.MsgInternalServerError)))
+		return
+	}
+
+	c.JSON(http.StatusOK, common.NewResponse(common.WithSuccess(true)))
+}
+
+// ResumeQueue
+// @Summary 恢复下发
+// @Description 恢复指定发件账户的邮件下发，未指定发件人时解除全局暂停
+// @tags Admin
+// @Accept json
+// @Produce json
+// @Param data body domain.QueuePauseReq false "发件账户"
+// @Success 200 {object} common.Response "{"success":true,"msg":"","data":null}"
+// @Failure 400 {object} common.Response "{"success":false,"msg":"请求参数错误","data":null}"
+// @Failure 401 {object} common.Response "{"success":false,"msg":"用户未登录","data":null}"
+// @Failure 403 {object} common.Response "{"success":false,"msg":"访问受限","data":null}"
+// @Failure 500 {object} common.Response "{"success":false,"msg":"Internal Server Error","data":null}"
+// @Router /c/admin/queue/resume [post]
+func (ac *AdminController) ResumeQueue(c *gin.Context) {
+	req, ok := bindQueuePauseReq(c)
+	if !ok {
+		return
+	}
+
+	if err := ac.Queue.Resume(req.FromAddr); err != nil {
+		log.Printf("恢复下发失败: %v", err)
+		c.JSON(http.StatusInternalServerError, common.NewResponse(common.WithMsg(common.MsgInternalServerError)))
+		return
+	}
+
+	c.JSON(http.StatusOK, common.NewResponse(common.WithSuccess(true)))
+}
+
+// bindQueuePauseReq 解析暂停/恢复请求，请求体可以为空
+func bindQueuePauseReq(c *gin.Context) (domain.QueuePauseReq, bool) {
+	var req domain.QueuePauseReq
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, common.NewResponse(common.WithMsg(common.MsgInvalidParam)))
+		return req, false
+	}
+
+	req.FromAddr = strings.TrimSpace(req.FromAddr)
+	if req.FromAddr == domain.QueuePauseGlobal || len(req.FromAddr) > 100 {
+		c.JSON(http.StatusBadRequest, common.NewResponse(common.WithMsg(common.MsgInvalidParam)))
+		return req, false
+	}
+	return req, true
+}
@@ -0,0 +1,116 @@
+package controller
+
+import (
+	"errors"
+	log "github.com/sirupsen/logrus"
+	"msps/internal/app/api"
+	"msps/internal/app/model/common"
+	"msps/internal/app/model/domain"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ListRateLimits
+// @Summary 限流规则列表
+// @Description 获取所有发件账户、用户及SMTP服务器的限流规则和当前用量
+// @tags Admin
+// @Produce json
+// @Param scope query string false "范围(account/user/host)"
+// @Success 200 {object} common.Response "{"success":true,"msg":"","data":[]}"
+// @Failure 401 {object} common.Response "{"success":false,"msg":"用户未登录","data":null}"
+// @Failure 403 {object} common.Response "{"success":false,"msg":"访问受限","data":null}"
+// @Failure 500 {object} common.Response "{"success":false,"msg":"Internal Server Error","data":null}"
+// @Router /c/admin/rate_limits [get]
+func (ac *AdminController) ListRateLimits(c *gin.Context) {
+	query := ac.DB.Order("scope, `key`")
+	if scope := c.Query("scope"); scope != "" {
+		query = query.Where("scope = ?", scope)
+	}
+
+	var limits []domain.RateLimit
+	if err := query.Find(&limits).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, common.NewResponse(common.WithMsg("获取限流规则失败")))
+		return
+	}
+
+	usages, err := ac.Queue.RateLimitUsages(limits)
+	if err != nil {
+		log.Printf("统计限流用量失败: %v", err)
+		c.JSON(http.StatusInternalServerError, common.NewResponse(common.WithMsg(common.MsgInternalServerError)))
+		return
+	}
+
+	c.JSON(http.StatusOK, common.NewResponse(common.WithSuccess(true), common.WithPayload(usages)))
+}
+
+// SetRateLimit
+// @Summary 设置限流规则
+// @Description 新增或覆盖限流规则，各时间窗口为0时不限制。超出限制的邮件保留在队列中，用量下降后再下发
+// @tags Admin
+// @Accept json
+// @Produce json
+// @Param data body domain.RateLimitReq true "限流规则"
+// @Success 200 {object} common.Response "{"success":true,"msg":"","data":null}"
+// @Failure 400 {object} common.Response "{"success":false,"msg":"请求参数错误","data":null}"
+// @Failure 401 {object} common.Response "{"success":false,"msg":"用户未登录","data":null}"
+// @Failure 403 {object} common.Response "{"success":false,"msg":"访问受限","data":null}"
+// @Failure 500 {object} common.Response "{"success":false,"msg":"Internal Server Error","data":null}"
+// @Router /c/admin/rate_limits [put]
+func (ac *AdminController) SetRateLimit(c *gin.Context) {
+	var req domain.RateLimitReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, common.NewResponse(common.WithMsg(common.MsgInvalidParam)))
+		return
+	}
+
+	if req.Scope == domain.RateLimitScopeUser {
+		if _, err := strconv.ParseInt(req.Key, 10, 64); err != nil {
+			c.JSON(http.StatusBadRequest, common.NewResponse(common.WithMsg("用户限流的key须为用户ID")))
+			return
+		}
+	}
+
+	limit, err := ac.Queue.SetRateLimit(req)
+	if err != nil {
+		log.Printf("保存限流规则失败: %v", err)
+		c.JSON(http.StatusInternalServerError, common.NewResponse(common.WithMsg(common.MsgInternalServerError)))
+		return
+	}
+
+	c.JSON(http.StatusOK, common.NewResponse(common.WithSuccess(true), common.WithPayload(limit)))
+}
+
+// DeleteRateLimit
+// @Summary 删除限流规则
+// @Description 删除后该范围的邮件不再受此规则限制
+// @tags Admin
+// @Produce json
+// @Param id path int true "规则ID"
+// @Success 200 {object} common.Response "{"success":true,"msg":"","data":null}"
+// @Failure 400 {object} common.Response "{"success":false,"msg":"请求参数错误","data":null}"
+// @Failure 401 {object} common.Response "{"success":false,"msg":"用户未登录","data":null}"
+// @Failure 403 {object} common.Response "{"success":false,"msg":"访问受限","data":null}"
+// @Failure 404 {object} common.Response "{"success":false,"msg":"限流规则不存在","data":null}"
+// @Failure 500 {object} common.Response "{"success":false,"msg":"Internal Server Error","data":null}"
+// @Router /c/admin/rate_limits/{id} [delete]
+func (ac *AdminController) DeleteRateLimit(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, common.NewResponse(common.WithMsg(common.MsgInvalidParam)))
+		return
+	}
+
+	if err := ac.Queue.DeleteRateLimit(id); err != nil {
+		if errors.Is(err, api.ErrRateLimitNotFound) {
+			c.JSON(http.StatusNotFound, common.NewResponse(common.WithMsg("限流规则不存在")))
+			return
+		}
+		log.Printf("删除限流规则失败: %v", err)
+		c.JSON(http.StatusInternalServerError, common.NewResponse(common.WithMsg(common.MsgInternalServerError)))
+		return
+	}
+
+	c.JSON(http.StatusOK, common.NewResponse(common.WithSuccess(true)))
+}
@@ -611,7 +611,7 @@ func (uc *UserController) VerifyPassword(c *gin.Context) {
 			common.WithMsg("用户未登录或会话已过期")))
 		return
 	}
-	log.Printf("Received Authorization header: %s", currentUserID)
+	log.Printf("Received Authorization header: %d", currentUserID)
 
 	var input struct {
 		Password string `json:"password" binding:"required"`
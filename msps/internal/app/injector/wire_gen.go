@@ -16,18 +16,17 @@ import (
 // Injectors from wire.go:
 
 func BuildInjector(ctx context.Context) (*Injector, func(), error) {
-	agent := api.ProvideAgentSet()
-	client := api.ProvideClientSet()
 	db, err := router.InitDatabase()
 	if err != nil {
 		return nil, nil, err
 	}
+	mailQueue := api.ProvideMailQueue(db)
+	agent := api.ProvideAgentSet(db, mailQueue)
+	client := api.ProvideClientSet(db, mailQueue)
 	userController := controller.NewUserController(db)
-	emailController := controller.NewEmailController(db)
-	routerRouter, err := router.NewRouter(agent, client, userController, emailController, db)
-	if err != nil {
-		return nil, nil, err
-	}
+	emailController := controller.NewEmailController(db, userController, client, agent)
+	adminController := controller.NewAdminController(db, mailQueue, client)
+	routerRouter := router.NewRouter(agent, client, userController, emailController, adminController, db)
 	engine := initHttpServer(routerRouter)
 	injector := &Injector{
 		Engine: engine,
@@ -4,11 +4,13 @@ import (
 	"fmt"
 	"github.com/gin-gonic/gin"
 	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm"
 	"net/http"
 	"path"
 	"strings"
 
 	"msps/internal/app/model/common"
+	"msps/internal/app/model/domain"
 )
 
 // SkipperFunc 定义中间件跳过函数
@@ -159,3 +161,27 @@ func AuthMiddleware() gin.HandlerFunc {
 		c.Next()
 	}
 }
+
+// AdminMiddleware 仅允许管理员访问，需在AuthMiddleware之后使用
+func AdminMiddleware(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		username, exists := c.Get("username")
+		if !exists {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, common.NewResponse(common.WithMsg("用户未登录")))
+			return
+		}
+
+		var user domain.User
+		if err := db.Select("role", "status").Where("username = ?", username).First(&user).Error; err != nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, common.NewResponse(common.WithMsg("用户不存在")))
+			return
+		}
+
+		if user.Role != "admin" || user.Status != "active" {
+			c.AbortWithStatusJSON(http.StatusForbidden, common.NewResponse(common.WithMsg("访问受限")))
+			return
+		}
+
+		c.Next()
+	}
+}
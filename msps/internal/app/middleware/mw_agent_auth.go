@@ -0,0 +1,153 @@
+package middleware
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"msps/internal/app/model/common"
+	"msps/internal/app/model/domain"
+)
+
+const (
+	AgentIDHeader        = "X-Agent-ID"
+	AgentKeyHeader       = "X-Agent-Key"
+	AgentTimestampHeader = "X-Timestamp"
+	AgentNonceHeader     = "X-Nonce"
+	AgentSignatureHeader = "X-Signature"
+
+	// agentSignatureWindow 签名时间戳允许的最大偏差，超出的请求视为重放
+	agentSignatureWindow = 5 * time.Minute
+	// maxAgentBodySize agent请求体大小上限
+	maxAgentBodySize = 8 << 20
+)
+
+// AgentSignature 计算agent请求签名: HMAC-SHA256(secret, method\npath\ntimestamp\nnonce\nagentID\nsha256(body))
+func AgentSignature(secret, method, path, timestamp, nonce, agentID string, body []byte) string {
+	bodyHash := sha256.Sum256(body)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(method + "\n" + path + "\n" + timestamp + "\n" + nonce + "\n" + agentID + "\n"))
+	mac.Write([]byte(hex.EncodeToString(bodyHash[:])))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// nonceCache 记录签名有效期内已使用过的nonce
+type nonceCache struct {
+	mu        sync.Mutex
+	seen      map[string]time.Time
+	lastSweep time.Time
+}
+
+// use 登记nonce，nonce已使用过时返回false
+func (n *nonceCache) use(key string, now time.Time) bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if now.Sub(n.lastSweep) > agentSignatureWindow {
+		for k, expires := range n.seen {
+			if now.After(expires) {
+				delete(n.seen, k)
+			}
+		}
+		n.lastSweep = now
+	}
+
+	if expires, ok := n.seen[key]; ok && now.Before(expires) {
+		return false
+	}
+	// 时间戳最多可比当前时间晚一个窗口，nonce需保留两个窗口
+	n.seen[key] = now.Add(2 * agentSignatureWindow)
+	return true
+}
+
+// AgentAuthMiddleware 校验agent请求签名，凭证须由管理员签发且未被吊销
+func AgentAuthMiddleware(db *gorm.DB) gin.HandlerFunc {
+	nonces := &nonceCache{seen: make(map[string]time.Time)}
+
+	return func(c *gin.Context) {
+		agentID := c.GetHeader(AgentIDHeader)
+		keyID := c.GetHeader(AgentKeyHeader)
+		timestamp := c.GetHeader(AgentTimestampHeader)
+		nonce := c.GetHeader(AgentNonceHeader)
+		signature := c.GetHeader(AgentSignatureHeader)
+		if agentID == "" || keyID == "" || timestamp == "" || nonce == "" || signature == "" {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, common.NewResponse(common.WithMsg("缺少签名信息")))
+			return
+		}
+
+		ts, err := strconv.ParseInt(timestamp, 10, 64)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, common.NewResponse(common.WithMsg("时间戳格式错误")))
+			return
+		}
+		now := time.Now()
+		if skew := now.Sub(time.Unix(ts, 0)); skew > agentSignatureWindow || skew < -agentSignatureWindow {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, common.NewResponse(common.WithMsg("签名已过期")))
+			return
+		}
+
+		var cred domain.AgentCredential
+		if err := db.Where("id = ?", keyID).First(&cred).Error; err != nil {
+			if !errors.Is(err, gorm.ErrRecordNotFound) {
+				log.Printf("查询agent凭证失败: %v", err)
+			}
+			c.AbortWithStatusJSON(http.StatusUnauthorized, common.NewResponse(common.WithMsg("凭证无效")))
+			return
+		}
+		if cred.RevokedAt != nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, common.NewResponse(common.WithMsg("凭证已吊销")))
+			return
+		}
+		if cred.AgentID != "" && cred.AgentID != agentID {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, common.NewResponse(common.WithMsg("凭证与agent不匹配")))
+			return
+		}
+
+		body, err := io.ReadAll(io.LimitReader(c.Request.Body, maxAgentBodySize))
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, common.NewResponse(common.WithMsg(common.MsgInvalidParam)))
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		expected := AgentSignature(cred.Secret, c.Request.Method, c.Request.URL.Path, timestamp, nonce, agentID, body)
+		if !hmac.Equal([]byte(expected), []byte(signature)) {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, common.NewResponse(common.WithMsg("签名错误")))
+			return
+		}
+
+		if !nonces.use(keyID+":"+nonce, now) {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, common.NewResponse(common.WithMsg("重复的请求")))
+			return
+		}
+
+		// 凭证首次使用时与agent绑定，之后只能由该agent使用
+		updates := map[string]interface{}{"last_used_at": now}
+		query := db.Model(&domain.AgentCredential{}).Where("id = ?", cred.ID)
+		if cred.AgentID == "" {
+			updates["agent_id"] = agentID
+			query = query.Where("agent_id IS NULL OR agent_id = ?", agentID)
+		}
+		result := query.Updates(updates)
+		if result.Error != nil {
+			log.Printf("更新agent凭证失败: %v", result.Error)
+		} else if cred.AgentID == "" && result.RowsAffected == 0 {
+			// 并发请求中凭证已被其他agent绑定
+			c.AbortWithStatusJSON(http.StatusUnauthorized, common.NewResponse(common.WithMsg("凭证与agent不匹配")))
+			return
+		}
+
+		c.Set("agent_id", agentID)
+		c.Next()
+	}
+}
@@ -1,8 +1,71 @@
 package domain
 
+import "time"
+
+// HeartbeatReq agent心跳请求
+type HeartbeatReq struct {
+	ID       string   `json:"id" binding:"required"` // agent唯一标识(机器码)
+	Hostname string   `json:"hostname"`              // agent所在主机名
+	Version  string   `json:"version"`               // agent版本
+	Tags     []string `json:"tags"`                  // agent所属的池或能力标签，如dc-east、can-reach-smtp.qq.com
+}
+
+// AgentNode 已注册的agent，通过心跳维护
+type AgentNode struct {
+	ID          string    `gorm:"type:varchar(64);primaryKey" json:"id"`
+	Hostname    string    `gorm:"type:varchar(255);default:null" json:"hostname"`
+	IP          string    `gorm:"type:varchar(45);default:null" json:"ip"`
+	Version     string    `gorm:"type:varchar(50);default:null" json:"version"`
+	Tags        []string  `gorm:"type:varchar(1024);serializer:json" json:"tags"` // 心跳中上报的标签，决定可获取哪些池的邮件
+	FirstSeenAt time.Time `gorm:"not null" json:"first_seen_at"`
+	LastSeenAt  time.Time `gorm:"not null;index" json:"last_seen_at"`
+	Online      bool      `gorm:"-" json:"online"` // 由最近一次心跳时间推算
+}
+
+// AgentCredential 管理员为agent签发的签名凭证，首次使用时与agent绑定
+type AgentCredential struct {
+	ID         string     `gorm:"type:varchar(36);primaryKey" json:"id"` // 凭证标识，agent通过X-Agent-Key携带
+	Secret     string     `gorm:"type:varchar(64);not null" json:"-"`    // HMAC签名密钥，仅在签发时返回一次
+	Name       string     `gorm:"type:varchar(100);default:null" json:"name"`
+	AgentID    string     `gorm:"type:varchar(64);default:null;index" json:"agent_id"` // 绑定的agent
+	CreatedAt  time.Time  `gorm:"column:created_at" json:"created_at"`
+	LastUsedAt *time.Time `gorm:"default:null" json:"last_used_at"`
+	RevokedAt  *time.Time `gorm:"default:null" json:"revoked_at"` // 吊销时间，吊销后的凭证不可再使用
+}
+
+// AgentEnrollReq 签发agent凭证请求
+type AgentEnrollReq struct {
+	Name    string `json:"name"`     // 备注名称
+	AgentID string `json:"agent_id"` // 预先绑定的agent，为空时在首次使用时绑定
+}
+
+// AgentEnrollResp 签发的agent凭证，密钥仅返回这一次
+type AgentEnrollResp struct {
+	KeyID  string `json:"key_id"`
+	Secret string `json:"secret"`
+}
+
+// EmailFetchReq agent获取邮件请求
+type EmailFetchReq struct {
+	Limit int `json:"limit"` // 本次最多获取的邮件数量，为空时获取一封
+	Wait  int `json:"wait"`  // 队列为空时最多等待的秒数，为空时立即返回
+}
+
 type EmailVerifyReq struct {
-	ID      string `json:"id"`      // 邮件唯一标识
-	Success bool   `json:"success"` // 邮件发送是否成功
+	ID         string            `json:"id"`                          // 邮件唯一标识
+	LeaseID    string            `json:"lease_id" binding:"required"` // 获取邮件时得到的租约标识
+	Success    bool              `json:"success"`                     // 邮件发送是否成功
+	Temporary  bool              `json:"temporary"`                   // 失败是否为临时错误(4xx、超时、连接重置等)
+	Error      string            `json:"error,omitempty"`             // 失败原因
+	Recipients []RecipientResult `json:"recipients,omitempty"`        // 每个收件人的投递结果
+}
+
+// RecipientResult 单个收件人的投递结果
+type RecipientResult struct {
+	Addr     string `json:"addr"`            // 收件人地址
+	Accepted bool   `json:"accepted"`        // SMTP服务器是否接受该收件人
+	Code     int    `json:"code,omitempty"`  // SMTP回复码
+	Reply    string `json:"reply,omitempty"` // SMTP回复内容
 }
 
 type EmailProbeReq struct {
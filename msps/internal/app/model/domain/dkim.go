@@ -0,0 +1,78 @@
+package domain
+
+import "time"
+
+// DKIM密钥算法
+const (
+	DKIMAlgorithmRSA     = "rsa"
+	DKIMAlgorithmEd25519 = "ed25519"
+)
+
+// DKIM密钥状态
+const (
+	DKIMKeyStatusActive   = "active"
+	DKIMKeyStatusDisabled = "disabled"
+)
+
+// DKIMKey 发件域名的DKIM签名密钥。同一域名有多个启用的密钥时使用最新的一个签名，
+// 轮换密钥时可先以disabled创建新密钥，DNS记录生效后再启用
+type DKIMKey struct {
+	ID         int64     `gorm:"primaryKey;autoIncrement" json:"id"`
+	Domain     string    `gorm:"type:varchar(255);not null;uniqueIndex:idx_dkim_keys_domain_selector" json:"domain"`
+	Selector   string    `gorm:"type:varchar(63);not null;uniqueIndex:idx_dkim_keys_domain_selector" json:"selector"`
+	Algorithm  string    `gorm:"type:enum('rsa','ed25519');not null" json:"algorithm"`
+	PrivateKey string    `gorm:"type:text;not null" json:"-"` // 私钥(PKCS#8 PEM)，不对外返回
+	Status     string    `gorm:"type:enum('active','disabled');default:'active'" json:"status"`
+	CreatedAt  time.Time `gorm:"column:created_at" json:"created_at"`
+	UpdatedAt  time.Time `gorm:"column:updated_at" json:"updated_at"`
+}
+
+// DKIMGrant 管理员授权用户以发件域名签名。邮箱账户由用户自行添加且未验证所有权，
+// 只有得到授权的用户从该域名的邮箱账户发出的邮件才会使用DKIM密钥签名
+type DKIMGrant struct {
+	ID        int64     `gorm:"primaryKey;autoIncrement" json:"id"`
+	Domain    string    `gorm:"type:varchar(255);not null;uniqueIndex:idx_dkim_grants_domain_user" json:"domain"`
+	UserID    int64     `gorm:"not null;uniqueIndex:idx_dkim_grants_domain_user" json:"user_id"`
+	CreatedAt time.Time `gorm:"column:created_at" json:"created_at"`
+}
+
+// DKIMGrantReq 授权用户以发件域名签名
+type DKIMGrantReq struct {
+	Domain string `json:"domain" binding:"required,max=255"`
+	UserID int64  `json:"user_id" binding:"required,min=1"`
+}
+
+// DKIMKeyReq 上传或生成DKIM密钥
+type DKIMKeyReq struct {
+	Domain     string `json:"domain" binding:"required,max=255"`
+	Selector   string `json:"selector" binding:"required,max=63"`
+	Algorithm  string `json:"algorithm" binding:"omitempty,oneof=rsa ed25519"`  // 生成密钥使用的算法，默认rsa；上传私钥时以私钥类型为准
+	PrivateKey string `json:"private_key"`                                      // 私钥(PEM)，为空时由msps生成
+	Status     string `json:"status" binding:"omitempty,oneof=active disabled"` // 默认active
+}
+
+// DKIMKeyUpdateReq 启用或停用DKIM密钥
+type DKIMKeyUpdateReq struct {
+	Status string `json:"status" binding:"required,oneof=active disabled"`
+}
+
+// DKIMDNSRecord 需要在发件域名下发布的DKIM TXT记录
+type DKIMDNSRecord struct {
+	Name  string `json:"name"`  // 记录名，<selector>._domainkey.<domain>
+	Type  string `json:"type"`  // 记录类型，固定为TXT
+	Value string `json:"value"` // 记录值
+	Zone  string `json:"zone"`  // zone文件格式的记录，记录值已按255字节分段
+}
+
+// DKIMKeyResp DKIM密钥及其DNS记录
+type DKIMKeyResp struct {
+	DKIMKey
+	DNS DKIMDNSRecord `json:"dns"`
+}
+
+// DKIMSigner 下发给agent的签名密钥
+type DKIMSigner struct {
+	Domain     string `json:"domain"`      // 签名域(d=)
+	Selector   string `json:"selector"`    // 选择器(s=)
+	PrivateKey string `json:"private_key"` // 私钥(PEM)
+}
@@ -1,6 +1,14 @@
 package domain
 
-import "github.com/wneessen/go-mail"
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/wneessen/go-mail"
+)
 
 // EmailAddress 邮箱信息
 type EmailAddress struct {
@@ -10,21 +18,123 @@ type EmailAddress struct {
 
 // FileAttachment 文件附件信息
 type FileAttachment struct {
-	ContentType mail.ContentType `json:"content_type"` // 文件类型
-	Encoding    mail.Encoding    `json:"encoding"`     // 文件编码(Bas64, quoted-printable)
-	Name        string           `json:"name"`         // 文件名
-	Content     []byte           `json:"content"`      // 文件内容(base64)
+	ContentType mail.ContentType `json:"content_type"`          // 文件类型
+	Encoding    mail.Encoding    `json:"encoding"`              // 文件编码(Bas64, quoted-printable)
+	Name        string           `json:"name"`                  // 文件名
+	Content     []byte           `json:"content"`               // 文件内容(base64)
+	Disposition string           `json:"disposition,omitempty"` // attachment(默认)或inline，inline的文件可在HTML正文中以cid:引用
+	ContentID   string           `json:"content_id,omitempty"`  // 内嵌文件的Content-ID(不含尖括号)，为空时使用文件名
+}
+
+// 附件的Content-Disposition
+const (
+	DispositionAttachment = "attachment" // 普通附件
+	DispositionInline     = "inline"     // 内嵌在正文中，如HTML中的图片
+)
+
+// ValidDisposition Content-Disposition是否受支持，Content-ID中不能含有空白和尖括号
+func (f *FileAttachment) ValidDisposition() bool {
+	switch strings.ToLower(f.Disposition) {
+	case "", DispositionAttachment, DispositionInline:
+	default:
+		return false
+	}
+	return !strings.ContainsAny(f.ContentID, "<> \t\r\n")
 }
 
+// SMTP连接加密方式
+const (
+	SMTPTLSSSL              = "ssl"               // 隐式TLS(通常为465端口)
+	SMTPTLSStartTLS         = "starttls"          // 必须通过STARTTLS升级
+	SMTPTLSStartTLSOptional = "starttls-optional" // 服务器支持时通过STARTTLS升级，否则明文发送
+	SMTPTLSNone             = "none"              // 不加密，仅用于内网中继
+)
+
 // SMTPServer SMTP服务器
 type SMTPServer struct {
-	Host string `json:"host"`
-	Port *int   `json:"port,omitempty"`
+	Host       string `json:"host"`
+	Port       *int   `json:"port,omitempty"`
+	TLS        string `json:"tls,omitempty"`         // 加密方式，为空时465端口使用ssl，其他端口使用starttls
+	SkipVerify bool   `json:"skip_verify,omitempty"` // 不校验服务器证书
+	CACert     string `json:"ca_cert,omitempty"`     // 校验服务器证书使用的CA证书(PEM)，为空时使用系统证书
+}
+
+// ValidTLS 加密方式是否受支持，CA证书是否可以解析
+func (s *SMTPServer) ValidTLS() bool {
+	switch strings.ToLower(s.TLS) {
+	case "", SMTPTLSSSL, SMTPTLSStartTLS, SMTPTLSStartTLSOptional, SMTPTLSNone:
+	default:
+		return false
+	}
+	return s.CACert == "" || x509.NewCertPool().AppendCertsFromPEM([]byte(s.CACert))
+}
+
+// TLSMode 实际使用的加密方式，未指定时465端口使用ssl，其他端口使用starttls
+func (s *SMTPServer) TLSMode() string {
+	if s.TLS != "" {
+		return strings.ToLower(s.TLS)
+	}
+	if s.Port == nil || *s.Port == 465 {
+		return SMTPTLSSSL
+	}
+	return SMTPTLSStartTLS
+}
+
+// EmailLease agent获取邮件时得到的租约
+type EmailLease struct {
+	ID        string    `json:"id"`         // 租约唯一标识
+	ExpiresAt time.Time `json:"expires_at"` // 租约到期时间，到期未确认的邮件会重新入队
 }
 
+// SMTP认证方式
+const (
+	SMTPAuthAuto            = "auto" // 由agent从服务器支持的方式中选择最安全的一种
+	SMTPAuthPlain           = "plain"
+	SMTPAuthPlainNoEnc      = "plain-noenc" // 在不加密的连接上使用PLAIN，仅用于tls为none的内网中继
+	SMTPAuthLogin           = "login"
+	SMTPAuthLoginNoEnc      = "login-noenc" // 在不加密的连接上使用LOGIN，仅用于tls为none的内网中继
+	SMTPAuthCramMD5         = "cram-md5"
+	SMTPAuthSCRAMSHA1       = "scram-sha-1"
+	SMTPAuthSCRAMSHA1Plus   = "scram-sha-1-plus"
+	SMTPAuthSCRAMSHA256     = "scram-sha-256"
+	SMTPAuthSCRAMSHA256Plus = "scram-sha-256-plus"
+	SMTPAuthXOAUTH2         = "xoauth2" // 密码为OAuth2访问令牌
+)
+
 type SMTPAuth struct {
-	User string `json:"user"` // 用户名
-	Pass string `json:"pass"` // 密码
+	User      string `json:"user"`                // 用户名
+	Pass      string `json:"pass"`                // 密码
+	Mechanism string `json:"mechanism,omitempty"` // 认证方式，为空时与auto相同
+}
+
+// ValidMechanism 认证方式是否受支持
+func (a *SMTPAuth) ValidMechanism() bool {
+	switch strings.ToLower(a.Mechanism) {
+	case "", SMTPAuthAuto, SMTPAuthPlain, SMTPAuthPlainNoEnc, SMTPAuthLogin, SMTPAuthLoginNoEnc, SMTPAuthCramMD5,
+		SMTPAuthSCRAMSHA1, SMTPAuthSCRAMSHA1Plus, SMTPAuthSCRAMSHA256, SMTPAuthSCRAMSHA256Plus, SMTPAuthXOAUTH2:
+		return true
+	}
+	return false
+}
+
+// ErrAuthTLSMismatch 认证方式与SMTP服务器的加密方式不能同时使用
+var ErrAuthTLSMismatch = errors.New("SMTP认证方式与加密设置不匹配")
+
+// CheckTLS 检查认证方式能否用于server的加密方式：PLAIN、LOGIN不会在不加密的连接上发送密码，
+// tls为none时须明确使用plain-noenc或login-noenc；后两者只能用于不加密的连接，避免降级为明文发送密码
+func (a *SMTPAuth) CheckTLS(server SMTPServer) error {
+	none := server.TLSMode() == SMTPTLSNone
+	switch strings.ToLower(a.Mechanism) {
+	case SMTPAuthPlain, SMTPAuthLogin:
+		if none {
+			return fmt.Errorf("%w: tls为none时密码会明文传输，需使用plain-noenc或login-noenc", ErrAuthTLSMismatch)
+		}
+	case SMTPAuthPlainNoEnc, SMTPAuthLoginNoEnc:
+		if !none {
+			return fmt.Errorf("%w: plain-noenc、login-noenc只能用于tls为none的连接", ErrAuthTLSMismatch)
+		}
+	}
+	return nil
 }
 
 // EmailReq 邮件请求
@@ -36,9 +146,31 @@ type EmailReq struct {
 	To          []EmailAddress   `json:"to"`                 // 收件人列表
 	CC          []EmailAddress   `json:"cc,omitempty"`       // 抄送列表
 	BCC         []EmailAddress   `json:"bcc,omitempty"`      // 密送列表
+	Priority    *mail.Importance `json:"priority,omitempty"` // 消息优先级，同时决定在队列中的发送顺序
 	ContentType mail.ContentType `json:"content_type"`       // 邮件正文类型
 	Encoding    *mail.Encoding   `json:"encoding,omitempty"` // 邮件编码
 	Subject     string           `json:"subject"`            // 邮件主题
 	Body        string           `json:"body"`               // 邮件正文
+	AltBody     string           `json:"alt_body,omitempty"` // HTML正文的纯文本版本，为空时由agent根据HTML生成
 	Attachments []FileAttachment `json:"files,omitempty"`    // 附件列表
+	SendAt      *time.Time       `json:"send_at,omitempty"`  // 定时发送时间，为空时立即发送
+	Pool        string           `json:"pool,omitempty"`     // 只允许带有该标签的agent发送，为空时使用发件账户的设置
+	Lease       *EmailLease      `json:"lease,omitempty"`    // 租约，仅在下发给agent时设置
+	SMIME       *SMIMEOptions    `json:"smime,omitempty"`    // S/MIME签名、加密选项
+	DKIM        *DKIMSigner      `json:"dkim,omitempty"`     // 发件域名的DKIM签名密钥，仅在下发给agent时设置
+
+	// S/MIME证书和私钥，仅在下发给agent时设置
+	SMIMEKeys *SMIMEKeys `json:"smime_keys,omitempty"`
+}
+
+// EmailSendResp 邮件提交结果
+type EmailSendResp struct {
+	ID        string     `json:"id"`                   // 服务端生成的邮件唯一标识，用于查询发送结果
+	Replayed  bool       `json:"replayed"`             // 是否为幂等键重复提交，为true时邮件未重复入队
+	HoldUntil *time.Time `json:"hold_until,omitempty"` // 撤回截止时间，此前邮件不会下发给agent，可通过取消接口撤回
+}
+
+// RescheduleReq 修改定时邮件发送时间请求
+type RescheduleReq struct {
+	SendAt time.Time `json:"send_at" binding:"required"` // 新的发送时间
 }
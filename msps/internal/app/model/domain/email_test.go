@@ -0,0 +1,39 @@
+package domain
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestSMTPAuthCheckTLS(t *testing.T) {
+	port25, port465 := 25, 465
+	tests := []struct {
+		mechanism string
+		server    SMTPServer
+		ok        bool
+	}{
+		{SMTPAuthPlain, SMTPServer{TLS: SMTPTLSStartTLS}, true},
+		{SMTPAuthPlain, SMTPServer{TLS: SMTPTLSNone}, false},
+		{"LOGIN", SMTPServer{TLS: "None"}, false},
+		{SMTPAuthPlainNoEnc, SMTPServer{TLS: SMTPTLSNone}, true},
+		{SMTPAuthLoginNoEnc, SMTPServer{TLS: SMTPTLSNone, Port: &port25}, true},
+		{SMTPAuthPlainNoEnc, SMTPServer{TLS: SMTPTLSStartTLSOptional}, false},
+		{SMTPAuthLoginNoEnc, SMTPServer{Port: &port465}, false},
+		{SMTPAuthLoginNoEnc, SMTPServer{Port: &port25}, false}, // 未指定时使用starttls
+		{"", SMTPServer{TLS: SMTPTLSNone}, true},
+		{SMTPAuthCramMD5, SMTPServer{TLS: SMTPTLSNone}, true},
+	}
+	for _, tt := range tests {
+		auth := SMTPAuth{Mechanism: tt.mechanism}
+		if !auth.ValidMechanism() {
+			t.Errorf("mechanism %q should be valid", tt.mechanism)
+		}
+		err := auth.CheckTLS(tt.server)
+		if (err == nil) != tt.ok {
+			t.Errorf("CheckTLS(%q, %+v) = %v, want ok=%v", tt.mechanism, tt.server, err, tt.ok)
+		}
+		if err != nil && !errors.Is(err, ErrAuthTLSMismatch) {
+			t.Errorf("CheckTLS(%q) error should wrap ErrAuthTLSMismatch: %v", tt.mechanism, err)
+		}
+	}
+}
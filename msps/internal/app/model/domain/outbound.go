@@ -0,0 +1,115 @@
+package domain
+
+import (
+	"time"
+
+	"github.com/wneessen/go-mail"
+)
+
+const (
+	OutboundStatusQueued     = "queued"     // 等待agent获取
+	OutboundStatusDispatched = "dispatched" // 已租给agent发送，租约到期未确认会重新入队
+	OutboundStatusSent       = "sent"       // 发送成功
+	OutboundStatusFailed     = "failed"     // 发送失败
+	OutboundStatusCancelled  = "cancelled"  // agent获取前被用户取消
+)
+
+// 队列优先级，数值越大越先被agent获取
+const (
+	OutboundPriorityLow    = 0 // 批量邮件
+	OutboundPriorityNormal = 1
+	OutboundPriorityHigh   = 2 // 密码重置、告警等
+)
+
+// OutboundPriority 将邮件的Importance映射为队列优先级
+func OutboundPriority(importance *mail.Importance) int {
+	if importance == nil {
+		return OutboundPriorityNormal
+	}
+	switch *importance {
+	case mail.ImportanceHigh, mail.ImportanceUrgent:
+		return OutboundPriorityHigh
+	case mail.ImportanceLow, mail.ImportanceNonUrgent:
+		return OutboundPriorityLow
+	default:
+		return OutboundPriorityNormal
+	}
+}
+
+// OutboundMessage 持久化的待发送邮件队列
+type OutboundMessage struct {
+	ID             int64      `gorm:"primaryKey;autoIncrement" json:"id"`
+	EmailReqID     string     `gorm:"type:varchar(36);not null;index" json:"email_req_id"`
+	Payload        string     `gorm:"type:longtext;not null" json:"-"`   // EmailReq的JSON内容(含附件)
+	UserID         int64      `gorm:"default:null;index" json:"user_id"` // 提交邮件的用户
+	FromAddr       string     `gorm:"type:varchar(100);default:null;index" json:"from_addr"`
+	SmtpHost       string     `gorm:"type:varchar(255);default:null;index" json:"smtp_host"` // 目标SMTP服务器
+	Subject        string     `gorm:"type:varchar(255);default:null" json:"subject"`
+	SendAt         *time.Time `gorm:"default:null" json:"send_at"`                     // 定时发送时间
+	Priority       int        `gorm:"default:1;index" json:"priority"`                 // 队列优先级
+	Pool           string     `gorm:"type:varchar(64);default:null;index" json:"pool"` // 只分配给带有该标签的agent
+	Status         string     `gorm:"type:enum('queued','dispatched','sent','failed','cancelled');default:'queued';index" json:"status"`
+	LeaseID        string     `gorm:"type:varchar(36);default:null;index" json:"lease_id"`
+	AgentID        string     `gorm:"type:varchar(64);default:null;index" json:"agent_id"` // 最近一次获取该邮件的agent
+	LeaseExpiresAt *time.Time `gorm:"default:null;index" json:"lease_expires_at"`
+	DispatchCount  int        `gorm:"default:0" json:"dispatch_count"`        // 被agent获取的次数
+	Attempts       int        `gorm:"default:0" json:"attempts"`              // agent上报的发送尝试次数
+	AvailableAt    *time.Time `gorm:"default:null;index" json:"available_at"` // 最早可被agent获取的时间，用于重试退避
+	LastError      string     `gorm:"type:varchar(512);default:null" json:"last_error"`
+	CreatedAt      time.Time  `gorm:"column:created_at" json:"created_at"`
+	UpdatedAt      time.Time  `gorm:"column:updated_at" json:"updated_at"`
+	DispatchedAt   *time.Time `gorm:"default:null" json:"dispatched_at"`
+	CompletedAt    *time.Time `gorm:"default:null;index" json:"completed_at"` // 发送成功、最终失败或取消的时间
+}
+
+// IdempotencyKey 客户端提交邮件时携带的幂等键，有效期内重复提交返回首次生成的邮件标识
+type IdempotencyKey struct {
+	ID         int64     `gorm:"primaryKey;autoIncrement" json:"id"`
+	UserID     int64     `gorm:"not null;uniqueIndex:idx_idempotency_keys_user_key" json:"user_id"`
+	Key        string    `gorm:"type:varchar(255);not null;uniqueIndex:idx_idempotency_keys_user_key" json:"key"`
+	EmailReqID string    `gorm:"type:varchar(36);not null" json:"email_req_id"`
+	CreatedAt  time.Time `gorm:"column:created_at;index" json:"created_at"`
+}
+
+const (
+	DeadLetterReasonRetriesExhausted = "retries_exhausted" // 临时失败达到最大尝试次数
+	DeadLetterReasonPermanentFailure = "permanent_failure" // 永久失败
+	DeadLetterReasonLeaseExhausted   = "lease_exhausted"   // 多次租约到期仍未收到agent确认
+
+	DeadLetterStatusPending   = "pending"   // 等待管理员处理
+	DeadLetterStatusRequeued  = "requeued"  // 已重新入队
+	DeadLetterStatusDiscarded = "discarded" // 已丢弃
+)
+
+// DeadLetter 多次发送失败的邮件，保留原始请求供管理员检查、修改后重新入队或丢弃
+type DeadLetter struct {
+	ID                int64      `gorm:"primaryKey;autoIncrement" json:"id"`
+	OutboundMessageID int64      `gorm:"not null;index" json:"outbound_message_id"`
+	EmailReqID        string     `gorm:"type:varchar(36);not null;index" json:"email_req_id"`
+	UserID            int64      `gorm:"default:null" json:"user_id"`
+	FromAddr          string     `gorm:"type:varchar(100);default:null" json:"from_addr"`
+	Subject           string     `gorm:"type:varchar(255);default:null" json:"subject"`
+	Payload           string     `gorm:"type:longtext;not null" json:"-"` // EmailReq的JSON内容，重新入队时使用
+	Reason            string     `gorm:"type:enum('retries_exhausted','permanent_failure','lease_exhausted');not null" json:"reason"`
+	LastError         string     `gorm:"type:varchar(512);default:null" json:"last_error"`
+	Attempts          int        `gorm:"default:0" json:"attempts"`
+	Status            string     `gorm:"type:enum('pending','requeued','discarded');default:'pending';index" json:"status"`
+	CreatedAt         time.Time  `gorm:"column:created_at" json:"created_at"`
+	UpdatedAt         time.Time  `gorm:"column:updated_at" json:"updated_at"`
+	ResolvedAt        *time.Time `gorm:"default:null" json:"resolved_at"` // 重新入队或丢弃的时间
+}
+
+// DeadLetterDetail 死信详情，包含原始邮件请求(不含SMTP密码)
+type DeadLetterDetail struct {
+	DeadLetter
+	Request EmailReq `json:"request"`
+}
+
+// DeadLetterUpdateReq 修改死信的收件人或发送凭证，字段为空时保持不变
+type DeadLetterUpdateReq struct {
+	To     []EmailAddress `json:"to"`
+	CC     []EmailAddress `json:"cc"`
+	BCC    []EmailAddress `json:"bcc"`
+	Server *SMTPServer    `json:"server"`
+	Auth   *SMTPAuth      `json:"auth"`
+}
@@ -0,0 +1,53 @@
+package domain
+
+import "time"
+
+// QueuePauseGlobal 暂停所有邮件下发时使用的发件人
+const QueuePauseGlobal = "*"
+
+// QueuePause 暂停下发的发件账户，暂停期间该账户的邮件保留在队列中，不会被agent获取
+type QueuePause struct {
+	FromAddr  string    `gorm:"type:varchar(100);primaryKey" json:"from_addr"` // 发件邮箱，为*时暂停全部下发
+	Reason    string    `gorm:"type:varchar(255);default:null" json:"reason"`
+	PausedBy  string    `gorm:"type:varchar(50);default:null" json:"paused_by"` // 操作的管理员
+	CreatedAt time.Time `gorm:"column:created_at" json:"created_at"`
+}
+
+// QueuePauseReq 暂停或恢复下发请求，未指定发件人时作用于全部邮件
+type QueuePauseReq struct {
+	FromAddr string `json:"from_addr"`
+	Reason   string `json:"reason"`
+}
+
+// QueueBreakdown 按发件账户或SMTP服务器统计的队列情况
+type QueueBreakdown struct {
+	Key            string     `json:"key"`
+	Queued         int64      `json:"queued"`           // 等待下发(含定时及等待重试)
+	InFlight       int64      `json:"in_flight"`        // 已下发等待确认
+	OldestQueuedAt *time.Time `json:"oldest_queued_at"` // 最早入队时间
+}
+
+// QueueThroughput 最近完成的邮件数量
+type QueueThroughput struct {
+	SentLastMinute   int64 `json:"sent_last_minute"`
+	SentLastHour     int64 `json:"sent_last_hour"`
+	FailedLastHour   int64 `json:"failed_last_hour"`
+	SentLastDay      int64 `json:"sent_last_day"`
+	FailedLastDay    int64 `json:"failed_last_day"`
+	CancelledLastDay int64 `json:"cancelled_last_day"`
+}
+
+// QueueStats 队列概况
+type QueueStats struct {
+	Ready         int64            `json:"ready"`          // 可立即下发
+	Delayed       int64            `json:"delayed"`        // 撤回窗口、定时或重试退避中
+	InFlight      int64            `json:"in_flight"`      // 租约有效的邮件
+	ExpiredLeases int64            `json:"expired_leases"` // 租约已过期、等待重新下发
+	OldestReadyAt *time.Time       `json:"oldest_ready_at"`
+	OldestAge     int64            `json:"oldest_age"` // 最早可下发邮件的等待时间(秒)
+	Throughput    QueueThroughput  `json:"throughput"`
+	BySender      []QueueBreakdown `json:"by_sender"`
+	ByHost        []QueueBreakdown `json:"by_host"`
+	Paused        bool             `json:"paused"` // 是否暂停了全部下发
+	Pauses        []QueuePause     `json:"pauses"`
+}
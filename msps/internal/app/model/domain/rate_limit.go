@@ -0,0 +1,52 @@
+package domain
+
+import "time"
+
+// 限流范围
+const (
+	RateLimitScopeAccount = "account" // 发件邮箱，Key为邮箱地址
+	RateLimitScopeUser    = "user"    // 用户，Key为用户ID
+	RateLimitScopeHost    = "host"    // SMTP服务器，Key为服务器地址
+)
+
+// RateLimit 发送频率限制，各时间窗口为0时不限制。超出限制的邮件保留在队列中，窗口内用量下降后再下发
+type RateLimit struct {
+	ID        int64     `gorm:"primaryKey;autoIncrement" json:"id"`
+	Scope     string    `gorm:"type:enum('account','user','host');not null;uniqueIndex:idx_rate_limits_scope_key" json:"scope"`
+	Key       string    `gorm:"type:varchar(255);not null;uniqueIndex:idx_rate_limits_scope_key" json:"key"`
+	PerMinute int       `gorm:"default:0" json:"per_minute"` // 每分钟最多下发数量
+	PerHour   int       `gorm:"default:0" json:"per_hour"`   // 每小时最多下发数量
+	PerDay    int       `gorm:"default:0" json:"per_day"`    // 每天最多下发数量
+	CreatedAt time.Time `gorm:"column:created_at" json:"created_at"`
+	UpdatedAt time.Time `gorm:"column:updated_at" json:"updated_at"`
+}
+
+// RateLimitReq 新增或修改限流规则，范围与Key相同的规则会被覆盖
+type RateLimitReq struct {
+	Scope     string `json:"scope" binding:"required,oneof=account user host"`
+	Key       string `json:"key" binding:"required,max=255"`
+	PerMinute int    `json:"per_minute" binding:"min=0"`
+	PerHour   int    `json:"per_hour" binding:"min=0"`
+	PerDay    int    `json:"per_day" binding:"min=0"`
+}
+
+// RateLimitUsage 限流规则及当前用量
+type RateLimitUsage struct {
+	RateLimit
+	UsedLastMinute int64 `json:"used_last_minute"`
+	UsedLastHour   int64 `json:"used_last_hour"`
+	UsedLastDay    int64 `json:"used_last_day"`
+	Limited        bool  `json:"limited"` // 是否已达到限制，达到后该范围的邮件暂不下发
+}
+
+// DispatchLog 邮件下发记录，用于统计限流窗口内的用量，保留一天。
+// 租约到期或重新入队重试的下发会删除记录，重新下发时再次计入
+type DispatchLog struct {
+	ID                int64     `gorm:"primaryKey;autoIncrement" json:"id"`
+	OutboundMessageID int64     `gorm:"not null" json:"outbound_message_id"`
+	LeaseID           string    `gorm:"type:varchar(36);default:null;index" json:"lease_id"` // 本次下发的租约
+	UserID            int64     `gorm:"default:null;index" json:"user_id"`
+	FromAddr          string    `gorm:"type:varchar(100);default:null;index" json:"from_addr"`
+	SmtpHost          string    `gorm:"type:varchar(255);default:null;index" json:"smtp_host"`
+	CreatedAt         time.Time `gorm:"column:created_at;index" json:"created_at"`
+}
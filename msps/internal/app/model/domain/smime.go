@@ -0,0 +1,33 @@
+package domain
+
+import "time"
+
+// SMIMEOptions 邮件的S/MIME选项
+type SMIMEOptions struct {
+	Sign    bool `json:"sign"`    // 使用发件账户的证书签名(multipart/signed)
+	Encrypt bool `json:"encrypt"` // 使用收件人的证书加密(application/pkcs7-mime)，所有收件人都须已上传证书
+}
+
+// SMIMEKeys 下发给agent的S/MIME证书和私钥
+type SMIMEKeys struct {
+	Certificate    string            `json:"certificate,omitempty"`     // 发件账户证书链(PEM)，签名时设置
+	PrivateKey     string            `json:"private_key,omitempty"`     // 发件账户私钥(PEM)，签名时设置
+	RecipientCerts map[string]string `json:"recipient_certs,omitempty"` // 收件人地址到证书(PEM)的映射，加密时设置
+}
+
+// SMIMECertificate 用户保存的收件人S/MIME证书，用于加密发给该收件人的邮件
+type SMIMECertificate struct {
+	ID          int64     `gorm:"primaryKey;autoIncrement" json:"id"`
+	UserID      int64     `gorm:"not null;uniqueIndex:idx_smime_certificates_user_email" json:"user_id"`
+	Email       string    `gorm:"type:varchar(100);not null;uniqueIndex:idx_smime_certificates_user_email" json:"email"`
+	Subject     string    `gorm:"type:varchar(255);default:null" json:"subject"`
+	Certificate string    `gorm:"type:text;not null" json:"certificate"` // 证书(PEM)
+	NotAfter    time.Time `gorm:"not null" json:"not_after"`             // 证书到期时间
+	CreatedAt   time.Time `gorm:"column:created_at" json:"created_at"`
+}
+
+// SMIMECertificateReq 上传收件人证书，同一收件人的证书会被覆盖
+type SMIMECertificateReq struct {
+	Email       string `json:"email" binding:"omitempty,email"` // 收件人地址，为空时使用证书中的邮箱地址
+	Certificate string `json:"certificate" binding:"required"`  // 证书(PEM)
+}
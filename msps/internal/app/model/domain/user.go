@@ -23,9 +23,68 @@ type UserMailAccount struct {
 	Email       string    `gorm:"unique;not null" json:"email"`
 	AuthCode    string    `gorm:"not null" json:"auth_code"`
 	DisplayName string    `gorm:"default:null" json:"display_name"`
+	Pool        string    `gorm:"type:varchar(64);default:null" json:"pool"` // 只允许带有该标签的agent发送此账户的邮件，为空时不限制
 	Status      string    `gorm:"type:enum('active', 'disabled');default:'active'" json:"status"`
 	CreatedAt   time.Time `gorm:"column:created_at" json:"created_at"`
 	UpdatedAt   time.Time `gorm:"column:updated_at" json:"updated_at"`
+
+	// 配置令牌端点和刷新令牌后，下发邮件时由msps换取访问令牌，agent使用XOAUTH2认证
+	OAuthTokenURL     string     `gorm:"column:oauth_token_url;type:varchar(255);default:null" json:"oauth_token_url"`         // OAuth2令牌端点
+	OAuthClientID     string     `gorm:"column:oauth_client_id;type:varchar(255);default:null" json:"oauth_client_id"`         // 客户端ID
+	OAuthClientSecret string     `gorm:"column:oauth_client_secret;type:varchar(255);default:null" json:"oauth_client_secret"` // 客户端密钥
+	OAuthScope        string     `gorm:"column:oauth_scope;type:varchar(255);default:null" json:"oauth_scope"`                 // 申请的权限范围，为空时沿用授权时的范围
+	OAuthRefreshToken string     `gorm:"column:oauth_refresh_token;type:text" json:"oauth_refresh_token"`                      // 刷新令牌
+	OAuthAccessToken  string     `gorm:"column:oauth_access_token;type:text" json:"-"`                                         // 缓存的访问令牌
+	OAuthExpiresAt    *time.Time `gorm:"column:oauth_expires_at;default:null" json:"-"`                                        // 访问令牌到期时间
+
+	// 配置后可按邮件选项对发出的邮件进行S/MIME签名
+	SMIMECert string `gorm:"column:smime_cert;type:text" json:"smime_cert"` // 签名证书链(PEM)，叶子证书在前
+	SMIMEKey  string `gorm:"column:smime_key;type:text" json:"smime_key"`   // 签名证书私钥(PEM)
+}
+
+// UsesOAuth 账户是否使用OAuth2认证
+func (a *UserMailAccount) UsesOAuth() bool {
+	return a.OAuthTokenURL != "" && a.OAuthRefreshToken != ""
+}
+
+// UserMailAccountResp 返回给用户的邮箱账户，OAuth2客户端密钥、刷新令牌和S/MIME私钥只写不读
+type UserMailAccountResp struct {
+	ID            int64     `json:"id"`
+	UserID        int64     `json:"user_id"`
+	Email         string    `json:"email"`
+	AuthCode      string    `json:"auth_code"`
+	DisplayName   string    `json:"display_name"`
+	Pool          string    `json:"pool"`
+	Status        string    `json:"status"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+	OAuthTokenURL string    `json:"oauth_token_url"`
+	OAuthClientID string    `json:"oauth_client_id"`
+	OAuthScope    string    `json:"oauth_scope"`
+	OAuthEnabled  bool      `json:"oauth_enabled"` // 是否已配置令牌端点和刷新令牌
+	SMIMECert     string    `json:"smime_cert"`
+	SMIMEEnabled  bool      `json:"smime_enabled"` // 是否已配置签名证书和私钥
+}
+
+// NewUserMailAccountResp 去掉账户中不应返回的密钥
+func NewUserMailAccountResp(a UserMailAccount) UserMailAccountResp {
+	return UserMailAccountResp{
+		ID:            a.ID,
+		UserID:        a.UserID,
+		Email:         a.Email,
+		AuthCode:      a.AuthCode,
+		DisplayName:   a.DisplayName,
+		Pool:          a.Pool,
+		Status:        a.Status,
+		CreatedAt:     a.CreatedAt,
+		UpdatedAt:     a.UpdatedAt,
+		OAuthTokenURL: a.OAuthTokenURL,
+		OAuthClientID: a.OAuthClientID,
+		OAuthScope:    a.OAuthScope,
+		OAuthEnabled:  a.UsesOAuth(),
+		SMIMECert:     a.SMIMECert,
+		SMIMEEnabled:  a.SMIMECert != "" && a.SMIMEKey != "",
+	}
 }
 
 type EmailRecord struct {
@@ -35,11 +94,28 @@ type EmailRecord struct {
 	ToUserID      int64     `gorm:"default:null" json:"to_user_id"`
 	ToEmail       string    `gorm:"type:varchar(100);not null;index" json:"to_email"`
 	RecipientType string    `gorm:"type:enum('to','cc','bcc');default:'to'" json:"recipient_type"`
-	Status        string    `gorm:"type:enum('pending', 'success', 'fail');default:'pending'" json:"status"`
+	Status        string    `gorm:"type:enum('pending', 'success', 'fail', 'cancelled');default:'pending'" json:"status"`
 	SentAt        time.Time `gorm:"default:null" json:"sent_at"`
 	EmailReqID    string    `gorm:"type:varchar(36);index" json:"email_req_id"`
 	RetryCount    int       `gorm:"default:0" json:"retry_count"`
 	LastCheckedAt time.Time `gorm:"default:null" json:"last_checked_at"`
+	SmtpCode      int       `gorm:"default:null" json:"smtp_code"`                    // 该收件人的SMTP回复码
+	SmtpReply     string    `gorm:"type:varchar(512);default:null" json:"smtp_reply"` // 该收件人的SMTP回复内容
+	AttemptCount  int       `gorm:"default:0" json:"attempt_count"`                   // 实际投递尝试次数
+}
+
+// EmailAttempt 邮件记录的每次投递尝试
+type EmailAttempt struct {
+	ID            int64      `gorm:"primaryKey;autoIncrement" json:"id"`
+	EmailRecordID int64      `gorm:"not null;index" json:"email_record_id"`
+	EmailReqID    string     `gorm:"type:varchar(36);index" json:"email_req_id"`
+	AgentID       string     `gorm:"type:varchar(64);default:null;index" json:"agent_id"` // 执行本次投递的agent
+	Attempt       int        `gorm:"not null" json:"attempt"`                             // 第几次尝试
+	Result        string     `gorm:"type:enum('success', 'temp_fail', 'perm_fail');not null" json:"result"`
+	SmtpCode      int        `gorm:"default:null" json:"smtp_code"`
+	SmtpReply     string     `gorm:"type:varchar(512);default:null" json:"smtp_reply"`
+	NextAttemptAt *time.Time `gorm:"default:null" json:"next_attempt_at"` // 临时失败时下一次重试时间
+	CreatedAt     time.Time  `gorm:"column:created_at" json:"created_at"`
 }
 
 type Blacklist struct {
@@ -51,5 +127,5 @@ type Blacklist struct {
 }
 
 func Migrate(db *gorm.DB) error {
-	return db.AutoMigrate(&User{}, &UserMailAccount{}, &EmailRecord{}, &Blacklist{})
+	return db.AutoMigrate(&User{}, &UserMailAccount{}, &EmailRecord{}, &Blacklist{}, &OutboundMessage{}, &EmailAttempt{}, &AgentNode{}, &AgentCredential{}, &DeadLetter{}, &IdempotencyKey{}, &QueuePause{}, &RateLimit{}, &DispatchLog{}, &DKIMKey{}, &DKIMGrant{}, &SMIMECertificate{})
 }
@@ -1,10 +1,15 @@
 package router
 
-import "github.com/gin-gonic/gin"
+import (
+	"github.com/gin-gonic/gin"
+	"msps/internal/app/middleware"
+)
 
 // registerAgentApi 注册有关agent的API
 func (r *Router) registerAgentApi(engine *gin.Engine) {
 	g := engine.Group("/a")
+	// 所有agent请求均需使用管理员签发的凭证签名
+	g.Use(middleware.AgentAuthMiddleware(r.db))
 	// 健康检查
 	g.POST("/h", r.AgentApi.HealthCheck)
 	// 邮件发送处理
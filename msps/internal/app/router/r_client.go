@@ -20,6 +20,7 @@ func (r *Router) RegisterClientApi(engine *gin.Engine) {
 		g.POST("/email/send", r.ClientApi.HandleSentEmail)
 		g.POST("/check_blacklist", r.ClientApi.CheckEmailBlacklist)
 		g.POST("/email/:id/verify", r.ClientApi.HandleVerifyEmail)
+		g.DELETE("/email/:id", r.ClientApi.HandleCancelEmail)
 
 		// 邮件管理
 		e := g.Group("/email")
@@ -30,6 +31,13 @@ func (r *Router) RegisterClientApi(engine *gin.Engine) {
 			e.POST("/update_mail_status", r.EmailCtrl.UpdateMailAccountStatus)
 			e.GET("/get_black", r.EmailCtrl.GetBlacklist)
 
+			e.GET("/scheduled", r.ClientApi.HandleListScheduledEmails)
+			e.PUT("/scheduled/:id", r.ClientApi.HandleRescheduleEmail)
+			e.GET("/rate_limits", r.ClientApi.HandleListRateLimits)
+			e.GET("/smime_certs", r.ClientApi.HandleListSMIMECertificates)
+			e.PUT("/smime_certs", r.ClientApi.HandleSaveSMIMECertificate)
+			e.DELETE("/smime_certs/:id", r.ClientApi.HandleDeleteSMIMECertificate)
+
 			e.GET("/accounts", r.ClientApi.HandleListEmailAccounts)
 			e.POST("/add/accounts", r.ClientApi.HandleCreateEmailAccount)
 			e.PUT("/accounts/:id", r.ClientApi.HandleUpdateEmailAccount)
@@ -49,5 +57,39 @@ func (r *Router) RegisterClientApi(engine *gin.Engine) {
 			u.POST("/search_user", r.UserCtrl.SearchUsers)
 			u.POST("/update_userprofile", r.UserCtrl.UpdateUserProfile)
 		}
+
+		// 管理员接口
+		m := g.Group("/admin")
+		m.Use(middleware.AdminMiddleware(r.db))
+		{
+			m.GET("/agents", r.AdminCtrl.ListAgents)
+			m.GET("/agents/:id/messages", r.AdminCtrl.ListAgentMessages)
+			m.GET("/agent_credentials", r.AdminCtrl.ListAgentCredentials)
+			m.POST("/agent_credentials", r.AdminCtrl.EnrollAgent)
+			m.DELETE("/agent_credentials/:id", r.AdminCtrl.RevokeAgentCredential)
+
+			m.GET("/dead_letters", r.AdminCtrl.ListDeadLetters)
+			m.GET("/dead_letters/:id", r.AdminCtrl.GetDeadLetter)
+			m.PUT("/dead_letters/:id", r.AdminCtrl.UpdateDeadLetter)
+			m.POST("/dead_letters/:id/requeue", r.AdminCtrl.RequeueDeadLetter)
+			m.DELETE("/dead_letters/:id", r.AdminCtrl.DiscardDeadLetter)
+
+			m.GET("/queue/stats", r.AdminCtrl.QueueStats)
+			m.POST("/queue/pause", r.AdminCtrl.PauseQueue)
+			m.POST("/queue/resume", r.AdminCtrl.ResumeQueue)
+
+			m.GET("/rate_limits", r.AdminCtrl.ListRateLimits)
+			m.PUT("/rate_limits", r.AdminCtrl.SetRateLimit)
+			m.DELETE("/rate_limits/:id", r.AdminCtrl.DeleteRateLimit)
+
+			m.GET("/dkim_keys", r.AdminCtrl.ListDKIMKeys)
+			m.POST("/dkim_keys", r.AdminCtrl.CreateDKIMKey)
+			m.GET("/dkim_keys/:id/dns", r.AdminCtrl.GetDKIMRecord)
+			m.PUT("/dkim_keys/:id", r.AdminCtrl.UpdateDKIMKey)
+			m.DELETE("/dkim_keys/:id", r.AdminCtrl.DeleteDKIMKey)
+			m.GET("/dkim_grants", r.AdminCtrl.ListDKIMGrants)
+			m.POST("/dkim_grants", r.AdminCtrl.CreateDKIMGrant)
+			m.DELETE("/dkim_grants/:id", r.AdminCtrl.DeleteDKIMGrant)
+		}
 	}
 }
@@ -28,6 +28,7 @@ type Router struct {
 	ClientApi *api.Client
 	UserCtrl  *controller.UserController
 	EmailCtrl *controller.EmailController
+	AdminCtrl *controller.AdminController
 	db        *gorm.DB
 }
 
@@ -36,12 +37,16 @@ func NewRouter(
 	client *api.Client,
 	userCtrl *controller.UserController,
 	emailCtrl *controller.EmailController,
+	adminCtrl *controller.AdminController,
+	db *gorm.DB,
 ) *Router {
 	return &Router{
 		AgentApi:  agent,
 		ClientApi: client,
 		UserCtrl:  userCtrl,
 		EmailCtrl: emailCtrl,
+		AdminCtrl: adminCtrl,
+		db:        db,
 	}
 }
 
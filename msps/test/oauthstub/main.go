@@ -0,0 +1,77 @@
+// oauthstub 本地调试用的OAuth2令牌端点，按refresh_token授权方式签发访问令牌。
+//
+// 使用方法:
+//
+//	go run ./test/oauthstub --addr 127.0.0.1:9090
+//
+// 并在config.yaml中将该地址加入oauth_trusted_hosts，发件账户的oauth_token_url设为http://127.0.0.1:9090/token
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"log"
+	"net/http"
+	"sync"
+)
+
+func main() {
+	addr := flag.String("addr", "127.0.0.1:9090", "监听地址")
+	expiresIn := flag.Int("expires-in", 3600, "访问令牌有效期(秒)")
+	refreshToken := flag.String("refresh-token", "", "只接受该刷新令牌，为空时接受任意刷新令牌")
+	rotate := flag.Bool("rotate", false, "每次刷新时返回新的刷新令牌")
+	flag.Parse()
+
+	var mu sync.Mutex
+
+	http.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		if err := r.ParseForm(); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]any{"error": "invalid_request", "error_description": err.Error()})
+			return
+		}
+		if r.PostForm.Get("grant_type") != "refresh_token" {
+			writeJSON(w, http.StatusBadRequest, map[string]any{"error": "unsupported_grant_type"})
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		token := r.PostForm.Get("refresh_token")
+		if token == "" || (*refreshToken != "" && token != *refreshToken) {
+			writeJSON(w, http.StatusBadRequest, map[string]any{"error": "invalid_grant", "error_description": "unknown refresh token"})
+			return
+		}
+
+		resp := map[string]any{
+			"access_token": "stub-" + randomHex(),
+			"token_type":   "Bearer",
+			"expires_in":   *expiresIn,
+		}
+		if *rotate {
+			*refreshToken = "stub-refresh-" + randomHex()
+			resp["refresh_token"] = *refreshToken
+		}
+		log.Printf("client_id=%s scope=%q 签发访问令牌", r.PostForm.Get("client_id"), r.PostForm.Get("scope"))
+		writeJSON(w, http.StatusOK, resp)
+	})
+
+	log.Printf("OAuth2令牌端点: http://%s/token", *addr)
+	log.Fatal(http.ListenAndServe(*addr, nil))
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func randomHex() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}